@@ -0,0 +1,63 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+// DefaultAppCertsDir is the directory, relative to the application root, that is searched for certificates if
+// $BP_CA_CERTS_APP_DIR is not set.
+const DefaultAppCertsDir = ".ca-certificates"
+
+// getCertsFromApplication returns the paths of all non-hidden files in dir except detached signatures. A relative dir
+// is resolved against appPath. If required is false a missing dir is not an error.
+func getCertsFromApplication(appPath string, dir string, required bool) ([]string, error) {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(appPath, dir)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) && !required {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read application certificate directory %q\n%w", dir, err)
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || strings.HasSuffix(entry.Name(), truststore.SignatureExtension) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to stat %q\n%w", path, err)
+		}
+		if info.Mode().IsRegular() {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
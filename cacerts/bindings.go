@@ -1,5 +1,5 @@
 /*
- * Copyright 2018-2020 the original author or authors.
+ * Copyright 2018-2024 the original author or authors.
  *
  * Licensed under the Apache License, Version 2.0 (the "License");
  * you may not use this file except in compliance with the License.
@@ -17,26 +17,74 @@
 package cacerts
 
 import (
-	"sort"
+	"fmt"
+	"slices"
+	"strconv"
 
 	"github.com/buildpacks/libcnb"
 
-	"github.com/paketo-buildpacks/libpak/bindings"
+	"github.com/paketo-buildpacks/libpak/bard"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+const (
+	BindingType = truststore.BindingType // BindingType is used to resolve bindings containing CA certificates
 )
 
+// Keys of the kubernetes.io/tls secret layout, see truststore.TLSKeyCACert.
 const (
-	BindingType = "ca-certificates" // BindingType is used to resolve bindings containing CA certificates
+	TLSKeyCACert     = truststore.TLSKeyCACert
+	TLSKeyCert       = truststore.TLSKeyCert
+	TLSKeyPrivateKey = truststore.TLSKeyPrivateKey
 )
 
-func getsCertsFromBindings(binds libcnb.Bindings) []string {
-	var paths []string
-	for _, bind := range bindings.Resolve(binds, bindings.OfType(BindingType)) {
-		for k := range bind.Secret {
-			if path, ok := bind.SecretFilePath(k); ok {
-				paths = append(paths, path)
+// DefaultBindingKeyPatterns are the patterns matched by a BindingKeyFilter without include patterns.
+var DefaultBindingKeyPatterns = truststore.DefaultBindingKeyPatterns
+
+// BindingKeyFilter selects the keys of a ca-certificates binding that are loaded as certificates.
+type BindingKeyFilter = truststore.BindingKeyFilter
+
+// ParseBindingKeyFilter parses a comma or whitespace separated list of glob patterns, see
+// truststore.ParseBindingKeyFilter.
+func ParseBindingKeyFilter(s string) (BindingKeyFilter, error) {
+	return truststore.ParseBindingKeyFilter(s)
+}
+
+// bindingPlanEntries returns the metadata of the ca-certificates plan entries requiring the certificates of bindings,
+// one entry per scope and embedding decision in the order they first appear in the bindings' files. Certificates of
+// a binding are embedded if its "embed" key is true or, if it does not have one, it is one of embedBindings.
+func bindingPlanEntries(binds libcnb.Bindings, filter BindingKeyFilter, embedBindings []string, logger bard.Logger) ([]PlanEntryMetadata, error) {
+	var entries []PlanEntryMetadata
+	index := map[string]int{}
+	for _, f := range truststore.BindingFiles(binds, filter, logger) {
+		if err := truststore.ValidateScope(f.Scope); err != nil {
+			return nil, fmt.Errorf("invalid value for key %q of binding %q\n%w", truststore.BindingKeyScope, f.Binding, err)
+		}
+
+		var embed *bool
+		if f.Embed != "" {
+			b, err := strconv.ParseBool(f.Embed)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for key %q of binding %q\n%w", truststore.BindingKeyEmbed, f.Binding, err)
 			}
+			embed = &b
+		} else if slices.Contains(embedBindings, f.Binding) {
+			b := true
+			embed = &b
+		}
+
+		key := f.Scope
+		if embed != nil {
+			key = fmt.Sprintf("%s/%t", f.Scope, *embed)
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(entries)
+			index[key] = i
+			entries = append(entries, PlanEntryMetadata{Embed: embed, Scope: f.Scope})
 		}
+		entries[i].Paths = append(entries[i].Paths, f.Path)
 	}
-	sort.Strings(paths)
-	return paths
+	return entries, nil
 }
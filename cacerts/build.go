@@ -17,9 +17,9 @@
 package cacerts
 
 import (
-	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"sort"
 	"strings"
 
@@ -27,6 +27,8 @@ import (
 
 	"github.com/paketo-buildpacks/libpak"
 	"github.com/paketo-buildpacks/libpak/bard"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
 )
 
 type Build struct {
@@ -38,6 +40,21 @@ type Build struct {
 //
 // If the buildpack plan contains an entry with name "ca-certificates" Build will contribute a build layer
 // that adds the ca certificates at the paths provided in the plan entry metadata to the system truststore.
+// See PlanEntryMetadata for the supported metadata. Unexpected plan entries are ignored. Certificates are checked
+// against the policy selected by BP_CA_CERTS_POLICY. If BP_CA_CERTS_ALLOWED_FINGERPRINTS or BP_CA_CERTS_SIGNING_KEYS
+// is set, certificates that are neither allowed nor signed are refused, at build time and, see PinnedIntegrity, by the
+// helper at launch time.
+//
+// Certificates of entries with scope "build" are only trusted at build time and never embedded, certificates of
+// entries with scope "launch" are only embedded and trusted at launch time. Certificates of entries without a scope
+// are trusted at build time and embedded if the entry's "embed" metadata is true or, if it is not set,
+// BP_EMBED_CERTS is true. If certificates are embedded, the image is labeled with their number, their fingerprints and
+// a digest of the fingerprints, see LabelEmbeddedCount, LabelEmbeddedFingerprints and LabelEmbeddedDigest.
+//
+// Build logs the CA certificates added and removed at build time and at launch time since the previous build,
+// including certificates that were all removed or moved to another scope. If BP_CA_CERTS_LOCKED is true, any such
+// change fails the build. The certificates are recorded in the persistent metadata, see
+// PersistentMetadataCertificates.
 func (b Build) Build(context libcnb.BuildContext) (libcnb.BuildResult, error) {
 	result := libcnb.NewBuildResult()
 
@@ -48,26 +65,66 @@ func (b Build) Build(context libcnb.BuildContext) (libcnb.BuildResult, error) {
 		return libcnb.BuildResult{}, fmt.Errorf("unable to create configuration resolver\n%w", err)
 	}
 
-	certDir, err := os.MkdirTemp("", "ca-certificates")
+	keys, _ := cr.Resolve("BP_CA_CERTS_BINDING_KEYS")
+	filter, err := ParseBindingKeyFilter(keys)
+	if err != nil {
+		return libcnb.BuildResult{}, fmt.Errorf("invalid value for key 'BP_CA_CERTS_BINDING_KEYS'\n%w", err)
+	}
+
+	name, _ := cr.Resolve("BP_CA_CERTS_POLICY")
+	policy, err := truststore.LookupPolicy(name)
+	if err != nil {
+		return libcnb.BuildResult{}, fmt.Errorf("invalid value for key 'BP_CA_CERTS_POLICY'\n%w", err)
+	}
+
+	integrity, err := newIntegrity(func(key string) string {
+		v, _ := cr.Resolve(key)
+		return v
+	}, "BP_CA_CERTS_ALLOWED_FINGERPRINTS", "BP_CA_CERTS_SIGNING_KEYS")
 	if err != nil {
-		return libcnb.BuildResult{}, fmt.Errorf("unable to create temporary directory for certificates\n%w", err)
+		return libcnb.BuildResult{}, err
 	}
 
-	var certPaths []string
+	mapping, _ := cr.Resolve("BP_CA_CERTS_PROCESS_TYPES")
+	processTypes, err := ParseProcessTypes(mapping)
+	if err != nil {
+		return libcnb.BuildResult{}, fmt.Errorf("invalid value for key 'BP_CA_CERTS_PROCESS_TYPES'\n%w", err)
+	}
+
+	bindingFiles := truststore.BindingFiles(context.Platform.Bindings, filter, b.Logger)
+
+	// certificates are loaded into one truststore per combination of build and launch time trust
+	embed := cr.ResolveBool("BP_EMBED_CERTS")
+	var trusts []trust
+	stores := map[trust]*truststore.Truststore{}
 	var contributedHelper bool
 	for _, e := range context.Plan.Entries {
 		switch strings.ToLower(e.Name) {
 		case PlanEntryCACerts:
-			paths, err := pathsFromEntryMetadata(e.Metadata)
+			md, err := NewPlanEntryMetadata(e.Metadata)
 			if err != nil {
-				return libcnb.BuildResult{}, fmt.Errorf("failed to decode CA certificate paths from plan entry:\n%w", err)
+				return libcnb.BuildResult{}, fmt.Errorf("failed to decode ca-certificates plan entry metadata\n%w", err)
+			}
+			for _, k := range md.Unknown {
+				b.Logger.Bodyf("Warning: ignoring unknown ca-certificates plan entry metadata key %q", k)
 			}
-			for _, p := range paths {
-				if extraPaths, err := SplitCerts(p, certDir); err != nil {
-					return libcnb.BuildResult{}, fmt.Errorf("failed to split certificates at path %s \n%w", p, err)
-				} else {
-					certPaths = append(certPaths, extraPaths...)
-				}
+			var t trust
+			if md.Embed != nil {
+				t.build, t.launch = truststore.TrustedAt(md.Scope, *md.Embed)
+			} else {
+				t.build, t.launch = truststore.TrustedAt(md.Scope, embed)
+			}
+			ts, ok := stores[t]
+			if !ok {
+				ts = truststore.New()
+				ts.Logger = b.Logger
+				ts.Policy = policy
+				ts.Integrity = integrity
+				stores[t] = ts
+				trusts = append(trusts, t)
+			}
+			if err := ts.AddPlanEntry(md, bindingFiles); err != nil {
+				return libcnb.BuildResult{}, fmt.Errorf("failed to load certificates from ca-certificates plan entry\n%w", err)
 			}
 		case PlanEntryCACertsHelper:
 			if contributedHelper {
@@ -84,36 +141,156 @@ func (b Build) Build(context libcnb.BuildContext) (libcnb.BuildResult, error) {
 			}
 			contributedHelper = true
 		default:
-			return libcnb.BuildResult{}, fmt.Errorf("received unexpected buildpack plan entry %q", e.Name)
+			b.Logger.Bodyf("Ignoring unexpected buildpack plan entry %q", e.Name)
 		}
 	}
 
-	if len(certPaths) > 0 {
-		sort.Strings(certPaths)
-		layer := NewTrustedCACerts(certPaths, cr.ResolveBool("BP_EMBED_CERTS"))
-		layer.Logger = b.Logger
+	if contributedHelper && integrity.Enabled() {
+		pinned := NewPinnedIntegrity(integrity)
+		pinned.Logger = b.Logger
+		result.Layers = append(result.Layers, pinned)
+	}
+
+	current := newTrustedCerts(trusts, stores)
+	previous, ok, err := previousTrustedCerts(context)
+	if err != nil {
+		return libcnb.BuildResult{}, fmt.Errorf("unable to read CA certificates of the previous build\n%w", err)
+	}
+	if ok {
+		if err := b.diff(previous, current, cr.ResolveBool("BP_CA_CERTS_LOCKED")); err != nil {
+			return libcnb.BuildResult{}, err
+		}
+	}
+	result.PersistentMetadata[PersistentMetadataCertificates] = current.metadata()
+
+	layers, err := b.caCertsLayers(trusts, stores, processTypes)
+	if err != nil {
+		return libcnb.BuildResult{}, err
+	}
+	for _, layer := range layers {
 		result.Layers = append(result.Layers, layer)
 	}
+	result.Labels = append(result.Labels, embeddedLabels(trusts, stores)...)
 
 	return result, nil
 }
 
-func pathsFromEntryMetadata(md map[string]interface{}) ([]string, error) {
-	rawPaths, ok := md["paths"]
-	if !ok {
-		return nil, errors.New("ca-certificates build plan entry is missing required metadata key \"dirs\"")
-	}
-	pathArr, ok := rawPaths.([]interface{})
-	if !ok {
-		return nil, errors.New("expected \"paths\" to be of type []interface{}")
-	}
-	certPaths := make([]string, len(pathArr))
-	for i, path := range pathArr {
-		var ok bool
-		certPaths[i], ok = path.(string)
-		if !ok {
-			return nil, errors.New("expected each item in \"paths\" to be of type string")
+// trust describes whether certificates are trusted at build and at launch time.
+type trust struct {
+	build  bool
+	launch bool
+}
+
+// caCertsLayers returns the layers trusting the certificates of stores. If the certificates trusted at build time and
+// at launch time differ, certificates trusted at build time are contributed to a build layer and certificates trusted
+// at launch time, i.e. embedded certificates, to a separate launch layer. Otherwise a single layer is contributed.
+// Embedded certificates whose origins are all mapped by processTypes are only trusted by the mapped process types.
+// Certificates that are not in a file of their own are written to a staging directory that is removed once the
+// layers have been contributed, or right away if the layers cannot be created.
+func (b Build) caCertsLayers(trusts []trust, stores map[trust]*truststore.Truststore, processTypes ProcessTypes) (_ []*TrustedCACerts, err error) {
+	var certs []truststore.Certificate
+	buildTime, launchTime := map[string]bool{}, map[string]bool{}
+	for _, t := range trusts {
+		for _, c := range stores[t].Certificates() {
+			if !buildTime[c.Fingerprint] && !launchTime[c.Fingerprint] {
+				certs = append(certs, c)
+			}
+			buildTime[c.Fingerprint] = buildTime[c.Fingerprint] || t.build
+			launchTime[c.Fingerprint] = launchTime[c.Fingerprint] || t.launch
+		}
+	}
+	if len(certs) == 0 {
+		return nil, nil
+	}
+
+	staging, err := newStagingDir()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(staging.path)
+		}
+	}()
+
+	paths, err := truststore.WriteFiles(certs, staging.path)
+	if err != nil {
+		return nil, err
+	}
+	var buildPaths, launchPaths []string
+	processPaths := map[string][]string{}
+	bindings := map[string]string{}
+	sources := map[string]string{}
+	launched := 0
+	for i, c := range certs {
+		sources[paths[i]] = c.Origins[0].Name
+		if sources[paths[i]] == "" {
+			sources[paths[i]] = c.Origins[0].Path
+		}
+		for _, o := range c.Origins {
+			if o.Kind == truststore.OriginBinding {
+				bindings[paths[i]] = o.Name
+				break
+			}
+		}
+		if buildTime[c.Fingerprint] {
+			buildPaths = append(buildPaths, paths[i])
 		}
+		if !launchTime[c.Fingerprint] {
+			continue
+		}
+		launched++
+
+		var names []string
+		for _, o := range c.Origins {
+			names = append(names, o.Name)
+		}
+		types := processTypes.For(names...)
+		if types == nil {
+			launchPaths = append(launchPaths, paths[i])
+			continue
+		}
+		b.Logger.Bodyf("Trusting CA certificate %q only for process types %s", c.Subject, strings.Join(types, ", "))
+		for _, t := range types {
+			processPaths[t] = append(processPaths[t], paths[i])
+		}
+	}
+	sort.Strings(buildPaths)
+	sort.Strings(launchPaths)
+	for _, p := range processPaths {
+		sort.Strings(p)
+	}
+
+	if len(processPaths) == 0 && (slices.Equal(buildPaths, launchPaths) || len(launchPaths) == 0) {
+		layer := NewTrustedCACerts(buildPaths, len(launchPaths) > 0)
+		layer.CertBindings = bindings
+		layer.CertSources = sources
+		layer.Logger = b.Logger
+		staging.add(layer)
+		return []*TrustedCACerts{layer}, nil
+	}
+
+	b.Logger.Bodyf("Trusting %d CA certificate(s) at build time and %d at launch time", len(buildPaths), launched)
+	for _, c := range certs {
+		if launchTime[c.Fingerprint] {
+			b.Logger.Bodyf("Embedding CA certificate %q from %s", c.Subject, c.Origins[0])
+		} else {
+			b.Logger.Bodyf("Not embedding CA certificate %q from %s", c.Subject, c.Origins[0])
+		}
+	}
+	var layers []*TrustedCACerts
+	if len(buildPaths) > 0 {
+		layer := NewTrustedCACerts(buildPaths, false)
+		layer.CertSources = sources
+		layer.Logger = b.Logger
+		staging.add(layer)
+		layers = append(layers, layer)
 	}
-	return certPaths, nil
+	layer := NewLaunchTrustedCACerts(launchPaths)
+	layer.ProcessCertPaths = processPaths
+	layer.CertBindings = bindings
+	layer.CertSources = sources
+	layer.Logger = b.Logger
+	staging.add(layer)
+	return append(layers, layer), nil
 }
@@ -17,13 +17,18 @@
 package cacerts_test
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/buildpacks/libcnb"
 	. "github.com/onsi/gomega"
 	"github.com/paketo-buildpacks/libpak"
+	"github.com/paketo-buildpacks/libpak/bard"
 	"github.com/sclevine/spec"
 
 	"github.com/paketo-buildpacks/ca-certificates/v3/cacerts"
@@ -73,13 +78,16 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 			Expect(result.Layers[0].Name()).To(Equal("ca-certificates"))
 			contributor, ok := result.Layers[0].(*cacerts.TrustedCACerts)
 			Expect(ok).To(BeTrue())
-			Expect(len(contributor.CertPaths)).To(Equal(3))
+			Expect(len(contributor.CertPaths)).To(Equal(2))
 			Expect(contributor.CertPaths).To(ConsistOf(
 				ContainSubstring(filepath.Join("testdata", "SecureTrust_CA.pem")),
-				ContainSubstring(filepath.Join("testdata", "SecureTrust_CA_Duplicate.pem")),
 				ContainSubstring(filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem")),
 			))
 		})
+
+		it("does not label the image if no certificates are embedded", func() {
+			Expect(result.Labels).To(BeEmpty())
+		})
 	})
 
 	context("plan includes multiple ca-certificates entries", func() {
@@ -115,15 +123,63 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 			Expect(result.Layers[0].Name()).To(Equal("ca-certificates"))
 			contributor, ok := result.Layers[0].(*cacerts.TrustedCACerts)
 			Expect(ok).To(BeTrue())
-			Expect(len(contributor.CertPaths)).To(Equal(3))
+			Expect(len(contributor.CertPaths)).To(Equal(2))
 			Expect(contributor.CertPaths).To(ConsistOf(
 				ContainSubstring(filepath.Join("testdata", "SecureTrust_CA.pem")),
-				ContainSubstring(filepath.Join("testdata", "SecureTrust_CA_Duplicate.pem")),
 				ContainSubstring(filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem")),
 			))
 		})
 	})
 
+	context("plan includes ca-certificates entry from a kubernetes.io/tls secret binding", func() {
+		var result libcnb.BuildResult
+
+		it.Before(func() {
+			dir := filepath.Join("..", "truststore", "testdata", "tls-secret")
+			ctx.Platform.Bindings = libcnb.Bindings{
+				{
+					Name:   "some-tls-secret",
+					Type:   cacerts.BindingType,
+					Path:   dir,
+					Secret: map[string]string{"ca.crt": "", "tls.crt": "", "tls.key": ""},
+				},
+			}
+			ctx.Plan.Entries = []libcnb.BuildpackPlanEntry{
+				{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"paths": []interface{}{
+							filepath.Join(dir, "ca.crt"),
+							filepath.Join(dir, "tls.crt"),
+						},
+					},
+				},
+			}
+			var err error
+			result, err = build.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		it("only trusts CA certificates from tls.crt", func() {
+			contributor, ok := result.Layers[0].(*cacerts.TrustedCACerts)
+			Expect(ok).To(BeTrue())
+			// The CA certificate in tls.crt is the same as ca.crt
+			Expect(contributor.CertPaths).To(ConsistOf(
+				filepath.Join("..", "truststore", "testdata", "tls-secret", "ca.crt"),
+			))
+		})
+
+		it("records the binding of each certificate", func() {
+			contributor := result.Layers[0].(*cacerts.TrustedCACerts)
+			Expect(contributor.CertBindings).To(Equal(map[string]string{
+				filepath.Join("..", "truststore", "testdata", "tls-secret", "ca.crt"): "some-tls-secret",
+			}))
+			Expect(contributor.CertSources).To(Equal(map[string]string{
+				filepath.Join("..", "truststore", "testdata", "tls-secret", "ca.crt"): "some-tls-secret",
+			}))
+		})
+	})
+
 	context("plan include ca-cert-helper entry", func() {
 		var result libcnb.BuildResult
 
@@ -186,15 +242,626 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 	})
 
 	context("plan includes unrecognized entry", func() {
+		var buf *bytes.Buffer
+
 		it.Before(func() {
+			buf = &bytes.Buffer{}
+			build.Logger = bard.NewLogger(buf)
 			ctx.Plan.Entries = []libcnb.BuildpackPlanEntry{
 				{Name: "unexpected-entry"},
 			}
 		})
 
-		it("returns an error", func() {
+		it("ignores the entry", func() {
+			result, err := build.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Layers).To(BeEmpty())
+			Expect(buf.String()).To(ContainSubstring(`Ignoring unexpected buildpack plan entry "unexpected-entry"`))
+		})
+	})
+
+	context("plan includes ca-certificates entry with invalid metadata", func() {
+		it.Before(func() {
+			ctx.Plan.Entries = []libcnb.BuildpackPlanEntry{
+				{
+					Name:     cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{"paths": []interface{}{"some-path", 1}},
+				},
+			}
+		})
+
+		it("returns an error naming the field", func() {
+			_, err := build.Build(ctx)
+			Expect(err).To(MatchError(ContainSubstring(`field "paths[1]": expected string, got int`)))
+		})
+	})
+
+	context("plan includes ca-certificates entry with inline certificates", func() {
+		var result libcnb.BuildResult
+
+		it.Before(func() {
+			raw, err := os.ReadFile(filepath.Join("testdata", "multiple-certs.pem"))
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx.Plan.Entries = []libcnb.BuildpackPlanEntry{
+				{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"certificates": []interface{}{string(raw)},
+						"unknown-key":  "some-value",
+					},
+				},
+			}
+			result, err = build.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		it("contributes a ca-certificates layer with the split certificates", func() {
+			Expect(result.Layers).To(HaveLen(1))
+			contributor, ok := result.Layers[0].(*cacerts.TrustedCACerts)
+			Expect(ok).To(BeTrue())
+			Expect(contributor.CertPaths).To(ConsistOf(
+				HaveSuffix("cert_0_plan.pem"),
+				HaveSuffix("cert_1_plan.pem"),
+			))
+		})
+
+		it("warns about unknown metadata keys", func() {
+			buf := &bytes.Buffer{}
+			build.Logger = bard.NewLogger(buf)
+
+			_, err := build.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(buf.String()).To(ContainSubstring(`Warning: ignoring unknown ca-certificates plan entry metadata key "unknown-key"`))
+		})
+
+		it("copies the split certificates into the layer and removes the temporary directory", func() {
+			contributor := result.Layers[0].(*cacerts.TrustedCACerts)
+			staging := filepath.Dir(contributor.CertPaths[0])
+			contributor.GenerateHashLinks = func(string, []string) error { return nil }
+
+			layer, err := ctx.Layers.Layer(contributor.Name())
+			Expect(err).NotTo(HaveOccurred())
+			layer, err = contributor.Contribute(layer)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(staging).NotTo(BeADirectory())
+			for _, name := range []string{"cert_0_plan.pem", "cert_1_plan.pem"} {
+				info, err := os.Stat(filepath.Join(layer.Path, "split-certs", name))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0644)))
+			}
+		})
+
+		it("removes the temporary directory if the layer cannot be contributed", func() {
+			contributor := result.Layers[0].(*cacerts.TrustedCACerts)
+			staging := filepath.Dir(contributor.CertPaths[0])
+			contributor.GenerateHashLinks = func(string, []string) error { return fmt.Errorf("test error") }
+
+			layer, err := ctx.Layers.Layer(contributor.Name())
+			Expect(err).NotTo(HaveOccurred())
+			_, err = contributor.Contribute(layer)
+			Expect(err).To(MatchError(ContainSubstring("test error")))
+
+			Expect(staging).NotTo(BeADirectory())
+		})
+	})
+
+	context("plan includes ca-certificates entries with scopes", func() {
+		it.Before(func() {
+			ctx.Plan.Entries = []libcnb.BuildpackPlanEntry{
+				{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"paths": []interface{}{filepath.Join("testdata", "SecureTrust_CA.pem")},
+						"scope": "build",
+					},
+				},
+				{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"paths": []interface{}{filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem")},
+						"scope": "launch",
+					},
+				},
+			}
+		})
+
+		it.After(func() {
+			os.Unsetenv("BP_EMBED_CERTS")
+		})
+
+		it("contributes separate build and launch layers", func() {
+			result, err := build.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Layers).To(HaveLen(2))
+
+			buildLayer := result.Layers[0].(*cacerts.TrustedCACerts)
+			Expect(buildLayer.Name()).To(Equal("ca-certificates"))
+			Expect(buildLayer.EmbeddedCerts).To(BeFalse())
+			Expect(buildLayer.CertPaths).To(Equal([]string{filepath.Join("testdata", "SecureTrust_CA.pem")}))
+
+			launchLayer := result.Layers[1].(*cacerts.TrustedCACerts)
+			Expect(launchLayer.Name()).To(Equal("ca-certificates-launch"))
+			Expect(launchLayer.LaunchOnly).To(BeTrue())
+			Expect(launchLayer.CertPaths).To(Equal([]string{filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem")}))
+		})
+
+		it("never embeds build scoped certificates", func() {
+			os.Setenv("BP_EMBED_CERTS", "true")
+			ctx.Plan.Entries = append(ctx.Plan.Entries, libcnb.BuildpackPlanEntry{
+				Name: cacerts.PlanEntryCACerts,
+				Metadata: map[string]interface{}{
+					"paths": []interface{}{filepath.Join("..", "truststore", "testdata", "tls-secret", "ca.crt")},
+				},
+			})
+
+			result, err := build.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Layers).To(HaveLen(2))
+			Expect(result.Layers[0].(*cacerts.TrustedCACerts).CertPaths).To(Equal([]string{
+				filepath.Join("..", "truststore", "testdata", "tls-secret", "ca.crt"),
+				filepath.Join("testdata", "SecureTrust_CA.pem"),
+			}))
+			Expect(result.Layers[1].(*cacerts.TrustedCACerts).CertPaths).To(Equal([]string{
+				filepath.Join("..", "truststore", "testdata", "tls-secret", "ca.crt"),
+				filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"),
+			}))
+		})
+
+		it("contributes a single layer if the certificates are trusted at build and launch time", func() {
+			ctx.Plan.Entries[0].Metadata["scope"] = "both"
+			ctx.Plan.Entries[1].Metadata["scope"] = "both"
+
+			result, err := build.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Layers).To(HaveLen(1))
+			Expect(result.Layers[0].(*cacerts.TrustedCACerts).EmbeddedCerts).To(BeTrue())
+		})
+	})
+
+	context("plan includes ca-certificates entries with embed", func() {
+		it.Before(func() {
+			ctx.Plan.Entries = []libcnb.BuildpackPlanEntry{
+				{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"paths": []interface{}{filepath.Join("testdata", "SecureTrust_CA.pem")},
+					},
+				},
+				{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"paths": []interface{}{filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem")},
+						"embed": true,
+					},
+				},
+			}
+		})
+
+		it("embeds only the selected certificates", func() {
+			buf := &bytes.Buffer{}
+			build.Logger = bard.NewLogger(buf)
+
+			result, err := build.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Layers).To(HaveLen(2))
+			Expect(result.Layers[0].(*cacerts.TrustedCACerts).CertPaths).To(Equal([]string{
+				filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"),
+				filepath.Join("testdata", "SecureTrust_CA.pem"),
+			}))
+			Expect(result.Layers[1].(*cacerts.TrustedCACerts).CertPaths).To(Equal([]string{
+				filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"),
+			}))
+			Expect(buf.String()).To(ContainSubstring(`Not embedding CA certificate "CN=SecureTrust CA,O=SecureTrust Corporation,C=US"`))
+			Expect(buf.String()).To(ContainSubstring(`Embedding CA certificate "OU=Go Daddy Class 2 Certification Authority`))
+		})
+
+		it("labels the image with the embedded certificates", func() {
+			result, err := build.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			fingerprint := "c3846bf24b9e93ca64274c0ec67c1ecc5e024ffcacd2d74019350e81fe546ae4"
+			Expect(result.Labels).To(Equal([]libcnb.Label{
+				{Key: "io.paketo.ca-certificates.embedded.count", Value: "1"},
+				{Key: "io.paketo.ca-certificates.embedded.fingerprints", Value: fingerprint},
+				{Key: "io.paketo.ca-certificates.embedded.digest", Value: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(fingerprint+"\n")))},
+			}))
+		})
+	})
+
+	context("BP_CA_CERTS_PROCESS_TYPES is set", func() {
+		it.Before(func() {
+			ctx.Plan.Entries = []libcnb.BuildpackPlanEntry{
+				{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"paths":  []interface{}{filepath.Join("testdata", "SecureTrust_CA.pem")},
+						"embed":  true,
+						"source": "corp",
+					},
+				},
+				{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"paths": []interface{}{filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem")},
+						"embed": true,
+					},
+				},
+			}
+		})
+
+		it.After(func() {
+			os.Unsetenv("BP_CA_CERTS_PROCESS_TYPES")
+		})
+
+		it("trusts mapped certificates only for the process types", func() {
+			os.Setenv("BP_CA_CERTS_PROCESS_TYPES", "corp=web,worker")
+
+			result, err := build.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Layers).To(HaveLen(2))
+
+			launchLayer := result.Layers[1].(*cacerts.TrustedCACerts)
+			Expect(launchLayer.LaunchOnly).To(BeTrue())
+			Expect(launchLayer.CertPaths).To(Equal([]string{filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem")}))
+			Expect(launchLayer.ProcessCertPaths).To(Equal(map[string][]string{
+				"web":    {filepath.Join("testdata", "SecureTrust_CA.pem")},
+				"worker": {filepath.Join("testdata", "SecureTrust_CA.pem")},
+			}))
+		})
+
+		it("returns an error for invalid mappings", func() {
+			os.Setenv("BP_CA_CERTS_PROCESS_TYPES", "corp")
+
+			_, err := build.Build(ctx)
+			Expect(err).To(MatchError(ContainSubstring("invalid value for key 'BP_CA_CERTS_PROCESS_TYPES'")))
+		})
+	})
+
+	context("previous build contributed the launch layer", func() {
+		var (
+			buf *bytes.Buffer
+
+			previous   map[string]interface{}
+			contribute func() libcnb.Layer
+		)
+
+		it.Before(func() {
+			buf = &bytes.Buffer{}
+			build.Logger = bard.NewLogger(buf)
+			ctx.Plan.Entries = []libcnb.BuildpackPlanEntry{
+				{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"paths":  []interface{}{filepath.Join("testdata", "SecureTrust_CA.pem")},
+						"scope":  "launch",
+						"source": "corp",
+					},
+				},
+			}
+
+			// contribute builds and contributes the launch layer. The lifecycle restores only the metadata of the
+			// launch layer of the previous build.
+			contribute = func() libcnb.Layer {
+				result, err := build.Build(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				contributor := result.Layers[len(result.Layers)-1].(*cacerts.TrustedCACerts)
+				Expect(contributor.LaunchOnly).To(BeTrue())
+
+				layer, err := ctx.Layers.Layer(contributor.Name())
+				Expect(err).NotTo(HaveOccurred())
+				if previous != nil {
+					Expect(os.WriteFile(layer.Path+".toml", []byte{}, 0644)).To(Succeed())
+					layer.Metadata = previous
+				}
+
+				layer, err = contributor.Contribute(layer)
+				Expect(err).NotTo(HaveOccurred())
+				previous = layer.Metadata
+				Expect(os.RemoveAll(layer.Path)).To(Succeed())
+				return layer
+			}
+
+			contribute()
+			buf.Reset()
+		})
+
+		it.After(func() {
+			os.Unsetenv("BP_CA_CERTS_PROCESS_TYPES")
+		})
+
+		it("reuses the layer if nothing changed", func() {
+			contribute()
+			Expect(buf.String()).To(ContainSubstring("Reusing"))
+		})
+
+		it("contributes the layer if the certificates changed", func() {
+			ctx.Plan.Entries[0].Metadata["paths"] = []interface{}{filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem")}
+
+			layer := contribute()
+			Expect(buf.String()).NotTo(ContainSubstring("Reusing"))
+			Expect(layer.Metadata).To(HaveKeyWithValue("certificates", HaveKey("c3846bf24b9e93ca64274c0ec67c1ecc5e024ffcacd2d74019350e81fe546ae4")))
+		})
+
+		it("contributes the layer if the process types changed", func() {
+			os.Setenv("BP_CA_CERTS_PROCESS_TYPES", "corp=web")
+
+			layer := contribute()
+			Expect(buf.String()).NotTo(ContainSubstring("Reusing"))
+			Expect(layer.Metadata).To(HaveKeyWithValue("process-paths", HaveKey("web")))
+		})
+
+		it("contributes the layer if the bindings changed", func() {
+			raw, err := os.ReadFile(filepath.Join("testdata", "SecureTrust_CA.pem"))
+			Expect(err).NotTo(HaveOccurred())
+			dir := t.TempDir()
+			Expect(os.WriteFile(filepath.Join(dir, "ca.pem"), raw, 0644)).To(Succeed())
+			binding := libcnb.Binding{Name: "some-binding", Type: cacerts.BindingType, Path: dir, Secret: map[string]string{"ca.pem": ""}}
+			ctx.Platform.Bindings = libcnb.Bindings{binding}
+			ctx.Plan.Entries[0].Metadata["paths"] = []interface{}{filepath.Join(dir, "ca.pem")}
+
+			contribute()
+			buf.Reset()
+			binding.Name = "other-binding"
+			ctx.Platform.Bindings = libcnb.Bindings{binding}
+
+			layer := contribute()
+			Expect(buf.String()).NotTo(ContainSubstring("Reusing"))
+			Expect(layer.Metadata).To(HaveKeyWithValue("bindings", HaveKeyWithValue(filepath.Join(dir, "ca.pem"), "other-binding")))
+		})
+	})
+
+	context("previous build", func() {
+		var buf *bytes.Buffer
+
+		const (
+			goDaddy     = "c3846bf24b9e93ca64274c0ec67c1ecc5e024ffcacd2d74019350e81fe546ae4"
+			secureTrust = "f1c1b50ae5a20dd8030ec9f6bc24823dd367b5255759b4e71b61fce9f7375d73"
+		)
+
+		it.Before(func() {
+			buf = &bytes.Buffer{}
+			build.Logger = bard.NewLogger(buf)
+
+			ctx.Plan.Entries = []libcnb.BuildpackPlanEntry{
+				{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"paths": []interface{}{filepath.Join("testdata", "SecureTrust_CA.pem")},
+						"scope": "build",
+					},
+				},
+			}
+		})
+
+		it.After(func() {
+			ctx.PersistentMetadata = nil
+			os.Unsetenv("BP_CA_CERTS_LOCKED")
+		})
+
+		// rebuild passes the persistent metadata of result to the next build
+		rebuild := func(result libcnb.BuildResult) {
+			ctx.PersistentMetadata = result.PersistentMetadata
+			buf.Reset()
+		}
+
+		it("records the certificates in the persistent metadata", func() {
+			result, err := build.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result.PersistentMetadata).To(HaveKeyWithValue("certificates", map[string]interface{}{
+				"build":  map[string]string{secureTrust: "CN=SecureTrust CA,O=SecureTrust Corporation,C=US"},
+				"launch": map[string]string{},
+			}))
+		})
+
+		it("does not log a diff without a previous build", func() {
+			_, err := build.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(buf.String()).NotTo(ContainSubstring("since the previous build"))
+		})
+
+		it("logs the certificates added and removed since the previous build", func() {
+			ctx.PersistentMetadata = map[string]interface{}{
+				"certificates": map[string]interface{}{
+					"build": map[string]interface{}{goDaddy: "OU=Go Daddy Class 2 Certification Authority,O=The Go Daddy Group\\, Inc.,C=US"},
+				},
+			}
+
+			_, err := build.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(buf.String()).To(ContainSubstring(`Added CA certificate "CN=SecureTrust CA,O=SecureTrust Corporation,C=US" (` + secureTrust + `) at build time since the previous build`))
+			Expect(buf.String()).To(ContainSubstring(`Removed CA certificate "OU=Go Daddy Class 2 Certification Authority,O=The Go Daddy Group\\, Inc.,C=US" (` + goDaddy + `) at build time since the previous build`))
+			Expect(buf.String()).To(ContainSubstring("1 CA certificate(s) added, 1 removed and 0 unchanged at build time since the previous build"))
+		})
+
+		it("diffs against the layer metadata of builds that did not record the certificates", func() {
+			Expect(os.WriteFile(filepath.Join(ctx.Layers.Path, "ca-certificates.toml"), []byte(`[types]
+build = true
+cache = true
+
+[metadata.certificates]
+`+goDaddy+` = "OU=Go Daddy Class 2 Certification Authority,O=The Go Daddy Group\\, Inc.,C=US"
+`), 0644)).To(Succeed())
+
+			_, err := build.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(buf.String()).To(ContainSubstring("1 CA certificate(s) added, 1 removed and 0 unchanged at build time since the previous build"))
+		})
+
+		context("BP_CA_CERTS_LOCKED is true", func() {
+			it.Before(func() {
+				result, err := build.Build(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				rebuild(result)
+
+				os.Setenv("BP_CA_CERTS_LOCKED", "true")
+			})
+
+			it("succeeds if the certificates are unchanged", func() {
+				_, err := build.Build(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(buf.String()).To(ContainSubstring("0 CA certificate(s) added, 0 removed and 1 unchanged at build time since the previous build"))
+			})
+
+			it("fails if the certificates changed", func() {
+				ctx.Plan.Entries[0].Metadata["paths"] = []interface{}{filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem")}
+
+				_, err := build.Build(ctx)
+				Expect(err).To(MatchError(ContainSubstring("trusted CA certificates changed since the previous build and BP_CA_CERTS_LOCKED is true")))
+				Expect(err).To(MatchError(ContainSubstring(`removed "CN=SecureTrust CA,O=SecureTrust Corporation,C=US" (` + secureTrust + `) at build time`)))
+			})
+
+			it("fails if all certificates were removed", func() {
+				ctx.Plan.Entries = nil
+
+				_, err := build.Build(ctx)
+				Expect(err).To(MatchError(ContainSubstring("trusted CA certificates changed since the previous build and BP_CA_CERTS_LOCKED is true")))
+				Expect(err).To(MatchError(ContainSubstring(`removed "CN=SecureTrust CA,O=SecureTrust Corporation,C=US" (` + secureTrust + `) at build time`)))
+			})
+
+			it("fails if certificates were added to a build without certificates", func() {
+				ctx.Plan.Entries = nil
+				os.Unsetenv("BP_CA_CERTS_LOCKED")
+				result, err := build.Build(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				rebuild(result)
+				os.Setenv("BP_CA_CERTS_LOCKED", "true")
+
+				ctx.Plan.Entries = []libcnb.BuildpackPlanEntry{
+					{
+						Name:     cacerts.PlanEntryCACerts,
+						Metadata: map[string]interface{}{"paths": []interface{}{filepath.Join("testdata", "SecureTrust_CA.pem")}},
+					},
+				}
+				_, err = build.Build(ctx)
+				Expect(err).To(MatchError(ContainSubstring(`added "CN=SecureTrust CA,O=SecureTrust Corporation,C=US" (` + secureTrust + `) at build time`)))
+			})
+
+			it("fails if the certificates were moved to scope launch", func() {
+				ctx.Plan.Entries[0].Metadata["scope"] = "launch"
+
+				_, err := build.Build(ctx)
+				Expect(err).To(MatchError(ContainSubstring("trusted CA certificates changed since the previous build and BP_CA_CERTS_LOCKED is true")))
+				Expect(err).To(MatchError(ContainSubstring(`removed "CN=SecureTrust CA,O=SecureTrust Corporation,C=US" (` + secureTrust + `) at build time`)))
+				Expect(err).To(MatchError(ContainSubstring(`added "CN=SecureTrust CA,O=SecureTrust Corporation,C=US" (` + secureTrust + `) at launch time`)))
+			})
+		})
+	})
+
+	context("BP_CA_CERTS_SIGNING_KEYS is set", func() {
+		it.Before(func() {
+			ctx.Platform.Bindings = libcnb.Bindings{
+				{
+					Name:   "some-binding",
+					Type:   cacerts.BindingType,
+					Path:   filepath.Join("..", "truststore", "testdata", "signed"),
+					Secret: map[string]string{"ca.pem": "", "ca.pem.sig": ""},
+				},
+			}
+			ctx.Plan.Entries = []libcnb.BuildpackPlanEntry{
+				{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"paths": []interface{}{filepath.Join("..", "truststore", "testdata", "signed", "ca.pem")},
+					},
+				},
+			}
+		})
+
+		it.After(func() {
+			os.Unsetenv("BP_CA_CERTS_SIGNING_KEYS")
+		})
+
+		it("adds certificates signed by a trusted key", func() {
+			raw, err := os.ReadFile(filepath.Join("..", "truststore", "testdata", "signed", "signing_key.pub"))
+			Expect(err).NotTo(HaveOccurred())
+			os.Setenv("BP_CA_CERTS_SIGNING_KEYS", string(raw))
+
+			result, err := build.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Layers).To(HaveLen(1))
+		})
+
+		it("refuses certificates signed by another key", func() {
+			raw, err := os.ReadFile(filepath.Join("..", "truststore", "testdata", "signed", "other_key.pub"))
+			Expect(err).NotTo(HaveOccurred())
+			os.Setenv("BP_CA_CERTS_SIGNING_KEYS", string(raw))
+
+			_, err = build.Build(ctx)
+			Expect(err).To(MatchError(ContainSubstring("signed by untrusted key")))
+		})
+
+		it("pins the signing keys at launch if the helper is contributed", func() {
+			raw, err := os.ReadFile(filepath.Join("..", "truststore", "testdata", "signed", "signing_key.pub"))
+			Expect(err).NotTo(HaveOccurred())
+			os.Setenv("BP_CA_CERTS_SIGNING_KEYS", string(raw))
+			ctx.Plan.Entries = append(ctx.Plan.Entries, libcnb.BuildpackPlanEntry{Name: cacerts.PlanEntryCACertsHelper})
+
+			result, err := build.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Layers).To(HaveLen(3))
+			pinned, ok := result.Layers[1].(*cacerts.PinnedIntegrity)
+			Expect(ok).To(BeTrue())
+			Expect(pinned.Name()).To(Equal("ca-certificates-pins"))
+
+			layer, err := ctx.Layers.Layer(pinned.Name())
+			Expect(err).NotTo(HaveOccurred())
+			layer, err = pinned.Contribute(layer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(layer.LayerTypes).To(Equal(libcnb.LayerTypes{Launch: true}))
+			Expect(layer.LaunchEnvironment["BPL_CA_CERTS_PINNED_SIGNING_KEYS.override"]).To(Equal(strings.Join(strings.Fields(string(raw))[:2], " ")))
+			Expect(layer.LaunchEnvironment["BPL_CA_CERTS_PINNED_FINGERPRINTS.override"]).To(BeEmpty())
+		})
+	})
+
+	context("BP_CA_CERTS_POLICY is set", func() {
+		it.Before(func() {
+			ctx.Platform.Bindings = libcnb.Bindings{
+				{
+					Name:   "some-binding",
+					Type:   cacerts.BindingType,
+					Path:   filepath.Join("..", "truststore", "testdata", "policy"),
+					Secret: map[string]string{"weak-rsa-sha1.pem": ""},
+				},
+			}
+			ctx.Plan.Entries = []libcnb.BuildpackPlanEntry{
+				{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"paths": []interface{}{filepath.Join("..", "truststore", "testdata", "policy", "weak-rsa-sha1.pem")},
+					},
+				},
+			}
+		})
+
+		it.After(func() {
+			os.Unsetenv("BP_CA_CERTS_POLICY")
+		})
+
+		it("warns about weak certificates by default", func() {
+			buf := &bytes.Buffer{}
+			build.Logger = bard.NewLogger(buf)
+
+			result, err := build.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Layers).To(HaveLen(1))
+			Expect(buf.String()).To(ContainSubstring(`Warning: CA certificate "CN=Weak RSA SHA1 CA" from key "weak-rsa-sha1.pem" of binding "some-binding" violates the "default" policy`))
+		})
+
+		it("rejects weak certificates with the strict policy", func() {
+			os.Setenv("BP_CA_CERTS_POLICY", "strict")
+
+			_, err := build.Build(ctx)
+			Expect(err).To(MatchError(ContainSubstring(`CA certificate "CN=Weak RSA SHA1 CA" from key "weak-rsa-sha1.pem" of binding "some-binding" violates the "strict" policy`)))
+		})
+
+		it("returns an error for unknown policies", func() {
+			os.Setenv("BP_CA_CERTS_POLICY", "lax")
+
 			_, err := build.Build(ctx)
-			Expect(err).To(MatchError(`received unexpected buildpack plan entry "unexpected-entry"`))
+			Expect(err).To(MatchError(ContainSubstring("invalid value for key 'BP_CA_CERTS_POLICY'")))
 		})
 	})
 }
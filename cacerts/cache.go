@@ -0,0 +1,188 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+const (
+	// cacheMarker is created in a cached certificate directory once it is complete. Its modification time is updated
+	// whenever the directory is reused.
+	cacheMarker = ".complete"
+
+	// cacheLock is locked while a cached certificate directory is populated, reused or removed.
+	cacheLock = ".lock"
+
+	// UnusedCertDirAge is the time after which a certificate directory that is not in SSL_CERT_DIR of any running
+	// process is removed from the cache.
+	UnusedCertDirAge = time.Hour
+)
+
+var cacheDirPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// DefaultCacheDir returns the directory the helper caches certificate directories in if neither ExecD.CacheDir nor
+// BPL_CA_CERTS_CACHE_DIR is set.
+func DefaultCacheDir() string {
+	return filepath.Join(os.TempDir(), "ca-certificates")
+}
+
+// cacheDir returns the directory to cache certificate directories in: BPL_CA_CERTS_CACHE_DIR, CacheDir or
+// DefaultCacheDir. If the directory is not a private directory of the current user, see makePrivateDir, other users
+// could plant certificates in it and a new private directory is returned instead.
+func (e *ExecD) cacheDir() (string, error) {
+	dir := e.GetEnv("BPL_CA_CERTS_CACHE_DIR")
+	if dir == "" {
+		dir = e.CacheDir
+	}
+	if dir == "" {
+		dir = DefaultCacheDir()
+	}
+
+	if err := makePrivateDir(dir); err != nil {
+		e.Logger.Bodyf("Not caching CA certificates in %q: %s", dir, err)
+		if dir, err = truststore.MkdirTemp("", "ca-certificates-"); err != nil {
+			return "", fmt.Errorf("failed to create temp dir\n%w", err)
+		}
+	}
+	return dir, nil
+}
+
+// makePrivateDir creates the directory at path with truststore.DirMode. If it already exists, it is an error if it is
+// a symbolic link, is not owned by the current user or is writable by other users.
+func makePrivateDir(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), truststore.DirMode); err != nil {
+		return fmt.Errorf("failed to create directory %q\n%w", filepath.Dir(path), err)
+	}
+	if err := os.Mkdir(path, truststore.DirMode); err == nil {
+		return os.Chmod(path, truststore.DirMode)
+	} else if !os.IsExist(err) {
+		return fmt.Errorf("failed to create directory %q\n%w", path, err)
+	}
+	return checkPrivateDir(path)
+}
+
+// cachedCertDir returns the directory in cacheDir containing the hash links for the certificates of ts. The directory
+// is named after a digest of the certificates and their paths, so processes starting with the same bindings reuse it.
+//
+// Processes may start at the same time. The directory is locked while it is populated or reused, so only the first
+// process populates it and directories are not removed while they are being reused, see removeUnusedCertDirs.
+func (e *ExecD) cachedCertDir(ts *truststore.Truststore, cacheDir string) (string, error) {
+	h := sha256.New()
+	for _, c := range ts.Certificates() {
+		fmt.Fprintf(h, "%s %s\n", c.Fingerprint, c.Path)
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+	certDir := filepath.Join(cacheDir, digest)
+
+	var unlock func()
+	for locked := false; !locked; {
+		if err := makePrivateDir(certDir); err != nil {
+			return "", err
+		}
+		var err error
+		// the directory may have been removed while waiting for the lock
+		if unlock, locked, err = lockDir(certDir, true); err != nil {
+			return "", err
+		}
+	}
+	defer unlock()
+
+	marker := filepath.Join(certDir, cacheMarker)
+	if _, err := os.Stat(marker); err == nil {
+		now := time.Now()
+		if err := os.Chtimes(marker, now, now); err != nil {
+			return "", fmt.Errorf("failed to update %q\n%w", marker, err)
+		}
+		e.Logger.Infof("Using %d cached additional CA certificate(s)", ts.Len())
+		return certDir, nil
+	}
+
+	// split certificates of a process that did not finish populating the directory
+	stale, err := filepath.Glob(filepath.Join(certDir, "..split-*"))
+	if err != nil {
+		return "", fmt.Errorf("failed to list split certificates in %q\n%w", certDir, err)
+	}
+	for _, dir := range stale {
+		if err := os.RemoveAll(dir); err != nil {
+			return "", fmt.Errorf("failed to remove %q\n%w", dir, err)
+		}
+	}
+
+	if err := e.writeCertDir(ts, certDir); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(marker, []byte(digest), truststore.FileMode); err != nil {
+		return "", fmt.Errorf("failed to write %q\n%w", marker, err)
+	}
+	return certDir, nil
+}
+
+// removeUnusedCertDirs removes the certificate directories in cacheDir, except keep, that are provably unused: they are
+// not in SSL_CERT_DIR of any running process, see CertDirsInUse, they have not been used for UnusedCertDirAge and no
+// other process holds their lock. This includes the directories of watchers that are no longer running.
+func (e *ExecD) removeUnusedCertDirs(cacheDir string, keep string) error {
+	if e.CertDirsInUse == nil {
+		return nil
+	}
+	inUse, err := e.CertDirsInUse()
+	if err != nil {
+		e.Logger.Debugf("Not removing unused CA certificates directories: %s", err)
+		return nil
+	}
+
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory %q\n%w", cacheDir, err)
+	}
+	for _, entry := range entries {
+		dir := filepath.Join(cacheDir, entry.Name())
+		if dir == keep || inUse[dir] || !entry.IsDir() ||
+			!(cacheDirPattern.MatchString(entry.Name()) || strings.HasPrefix(entry.Name(), "watch-")) {
+			continue
+		}
+
+		info, err := os.Stat(filepath.Join(dir, cacheMarker))
+		if err != nil {
+			info, err = entry.Info()
+		}
+		if err != nil || time.Since(info.ModTime()) < UnusedCertDirAge {
+			continue
+		}
+
+		unlock, locked, err := lockDir(dir, false)
+		if err != nil || !locked {
+			continue
+		}
+		e.Logger.Debugf("Removing unused CA certificates directory %q", entry.Name())
+		err = os.RemoveAll(dir)
+		unlock()
+		if err != nil {
+			return fmt.Errorf("failed to remove %q\n%w", dir, err)
+		}
+	}
+	return nil
+}
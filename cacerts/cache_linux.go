@@ -0,0 +1,110 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// ProcessCertDirs returns the directories in SSL_CERT_DIR of all running processes whose environment can be read,
+// which includes all processes of the current user.
+func ProcessCertDirs() (map[string]bool, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list processes\n%w", err)
+	}
+
+	dirs := map[string]bool{}
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		// the process may have exited or belong to another user
+		raw, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "environ"))
+		if err != nil {
+			continue
+		}
+		for _, v := range bytes.Split(raw, []byte{0}) {
+			if value, ok := bytes.CutPrefix(v, []byte(EnvCAPath+"=")); ok {
+				for _, dir := range filepath.SplitList(string(value)) {
+					dirs[filepath.Clean(dir)] = true
+				}
+			}
+		}
+	}
+	return dirs, nil
+}
+
+// checkPrivateDir returns an error if path is a symbolic link, is not owned by the current user or is writable by its
+// group or other users.
+func checkPrivateDir(path string) error {
+	var stat syscall.Stat_t
+	if err := syscall.Lstat(path, &stat); err != nil {
+		return fmt.Errorf("unable to stat %q\n%w", path, err)
+	}
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		return fmt.Errorf("%q is not a directory", path)
+	}
+	if int(stat.Uid) != os.Geteuid() {
+		return fmt.Errorf("%q is owned by uid %d instead of %d", path, stat.Uid, os.Geteuid())
+	}
+	if stat.Mode&0022 != 0 {
+		return fmt.Errorf("%q is writable by other users", path)
+	}
+	return nil
+}
+
+// lockDir locks the cacheLock file in dir, waiting for other processes if wait is true. The returned function releases
+// the lock. locked is false if the lock is held by another process and wait is false, or if dir was removed while
+// waiting for the lock.
+func lockDir(dir string, wait bool) (unlock func(), locked bool, err error) {
+	path := filepath.Join(dir, cacheLock)
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0644)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("unable to open %q\n%w", path, err)
+	}
+
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err == syscall.EWOULDBLOCK {
+		f.Close()
+		return nil, false, nil
+	} else if err != nil {
+		f.Close()
+		return nil, false, fmt.Errorf("unable to lock %q\n%w", path, err)
+	}
+
+	var held, current syscall.Stat_t
+	if err := syscall.Fstat(int(f.Fd()), &held); err != nil {
+		f.Close()
+		return nil, false, fmt.Errorf("unable to stat %q\n%w", path, err)
+	}
+	if err := syscall.Stat(path, &current); err != nil || held.Ino != current.Ino || held.Dev != current.Dev {
+		f.Close()
+		return nil, false, nil
+	}
+	return func() { f.Close() }, true, nil
+}
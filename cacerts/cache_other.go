@@ -0,0 +1,51 @@
+//go:build !linux
+
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ProcessCertDirs is only supported on linux.
+func ProcessCertDirs() (map[string]bool, error) {
+	return nil, errors.New("listing the environment of processes is only supported on linux")
+}
+
+// checkPrivateDir returns an error if path is a symbolic link or is writable by its group or other users.
+func checkPrivateDir(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("unable to stat %q\n%w", path, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%q is not a directory", path)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%q is writable by other users", path)
+	}
+	return nil
+}
+
+// lockDir only locks dir if wait is true, in which case it does nothing. Directories are never removed from the cache
+// as ProcessCertDirs is not supported.
+func lockDir(_ string, wait bool) (func(), bool, error) {
+	return func() {}, wait, nil
+}
@@ -17,20 +17,9 @@
 package cacerts
 
 import (
-	"bytes"
-	"crypto/sha1"
 	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/asn1"
-	"encoding/binary"
-	"encoding/pem"
-	"errors"
-	"fmt"
-	"os"
-	"path/filepath"
-	"regexp"
-	"sort"
-	"strings"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
 )
 
 // Environment variables and defaults used by openssl to load trusted CA certificates
@@ -43,6 +32,8 @@ const (
 
 	// DefaultCAFile provides the default CAfile on ubuntu
 	DefaultCAFile string = "/etc/ssl/certs/ca-certificates.crt"
+	// DefaultCAPath provides the default CApath on ubuntu
+	DefaultCAPath string = "/etc/ssl/certs"
 )
 
 // GenerateHashLinks generates symlinks the given directory point to the given certificates paths.
@@ -51,155 +42,38 @@ const (
 // conflict in which case D shall be incremented for the latter of the conflicting certs.
 //
 // These links are used by openssl to lookup a given CA by subject name.
+//
+// Deprecated: Use truststore.SyncHashLinks, which GenerateHashLinks calls and which can also be used on non-empty
+// directories.
 func GenerateHashLinks(dir string, certPaths []string) error {
-	hashes := map[uint32][]string{}
-	sort.Strings(certPaths)
-	for _, path := range certPaths {
-		raw, err := os.ReadFile(path)
-		if err != nil {
-			return fmt.Errorf("failed to read file at path %q\n%w", path, err)
-		}
-		cert, err := decodeOneCert(raw)
-		if err != nil {
-			return fmt.Errorf("failed to decode certificate from file at path %q\n%w", path, err)
-		}
-		hash, err := SubjectNameHash(cert)
-		if err != nil {
-			return fmt.Errorf("failed compute subject name hash for cert at path %q\n%w", path, err)
-		}
-		hashes[hash] = append(hashes[hash], path)
-	}
-	for hash, paths := range hashes {
-		for i, path := range paths {
-			name := fmt.Sprintf("%08x.%d", hash, i)
-			if err := os.Symlink(path, filepath.Join(dir, name)); err != nil {
-				return err
-			}
-		}
-	}
-	return nil
-}
-
-func decodeOneCert(raw []byte) (*x509.Certificate, error) {
-	block, rest := pem.Decode(raw)
-	if block == nil {
-		return nil, errors.New("failed find PEM data")
-	}
-	extra, _ := pem.Decode(rest)
-	if extra != nil {
-		return nil, errors.New("found multiple PEM blocks, expected exactly one")
-	}
-	cert, err := x509.ParseCertificate(block.Bytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse certficate\n%w", err)
-	}
-	return cert, nil
+	return truststore.SyncHashLinks(dir, certPaths)
 }
 
-// SubjectNameHash is a reimplementation of the X509_subject_name_hash in openssl. It computes the SHA-1
-// of the canonical encoding of the certificate's subject name and returns the 32-bit integer represented by the first
-// four bytes of the hash using little-endian byte order.
+// SubjectNameHash is a reimplementation of the X509_subject_name_hash in openssl.
+//
+// Deprecated: Use truststore.SubjectNameHash.
 func SubjectNameHash(cert *x509.Certificate) (uint32, error) {
-	name, err := CanonicalName(cert.RawSubject)
-	if err != nil {
-		return 0, fmt.Errorf("failed to compute canonical subject name\n%w", err)
-	}
-	hasher := sha1.New()
-	_, err = hasher.Write(name)
-	if err != nil {
-		return 0, fmt.Errorf("failed to compute sha1sum of canonical subject name\n%w", err)
-	}
-	sum := hasher.Sum(nil)
-	return binary.LittleEndian.Uint32(sum[:4]), nil
+	return truststore.SubjectNameHash(cert)
 }
 
-// canonicalSET holds a of canonicalATVs. Suffix SET ensures it is marshaled as a set rather than a sequence
-// by asn1.Marshal.
-type canonicalSET []canonicalATV
-
-// canonicalATV is similar to pkix.AttributeTypeAndValue but includes tag to ensure all values are marshaled as
-// ASN.1, UTF8String values
-type canonicalATV struct {
-	Type  asn1.ObjectIdentifier
-	Value string `asn1:"utf8"`
-}
-
-// CanonicalName accepts a DER encoded subject name and returns a "Canonical Encoding" matching that
-// returned by the x509_name_canon function in openssl. All string values are transformed with CanonicalString
-// and UTF8 encoded and the leading SEQ header is removed.
+// CanonicalName returns the canonical encoding of a DER encoded subject name as used by openssl.
 //
-// For more information see https://stackoverflow.com/questions/34095440/hash-algorithm-for-certificate-crl-directory.
+// Deprecated: Use truststore.CanonicalName.
 func CanonicalName(name []byte) ([]byte, error) {
-	var origSeq pkix.RDNSequence
-	_, err := asn1.Unmarshal(name, &origSeq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse subject name\n%w", err)
-	}
-	var result []byte
-	for _, origSet := range origSeq {
-		var canonSet canonicalSET
-		for _, origATV := range origSet {
-			origVal, ok := origATV.Value.(string)
-			if !ok {
-				return nil, errors.New("got unexpected non-string value")
-			}
-			canonSet = append(canonSet, canonicalATV{
-				Type:  origATV.Type,
-				Value: CanonicalString(origVal),
-			})
-		}
-		setBytes, err := asn1.Marshal(canonSet)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal canonical name\n%w", err)
-		}
-		result = append(result, setBytes...)
-	}
-	return result, nil
+	return truststore.CanonicalName(name)
 }
 
-// CanonicalString transforms the given string. All leading and trailing whitespace is trimmed
-// where whitespace is defined as a space, formfeed, tab, newline, carriage return, or vertical tab
-// character. Any remaining sequence of one or more consecutive whitespace characters in replaced with
-// a single ' '.
+// CanonicalString is a reimplementation of the asn1_string_canon in openssl.
 //
-// This is a reimplementation of the asn1_string_canon in openssl
+// Deprecated: Use truststore.CanonicalString.
 func CanonicalString(s string) string {
-	s = strings.TrimLeft(s, " \f\t\n\v")
-	s = strings.TrimRight(s, " \f\t\n\v")
-	s = strings.ToLower(s)
-	return string(regexp.MustCompile(`[[:space:]]+`).ReplaceAll([]byte(s), []byte(" ")))
+	return truststore.CanonicalString(s)
 }
 
+// SplitCerts splits the certificates in the file at path into files in certDir that each contain exactly one
+// certificate.
+//
+// Deprecated: Use truststore.SplitCerts.
 func SplitCerts(path string, certDir string) ([]string, error) {
-	var paths []string
-	var block *pem.Block
-	var rest []byte
-
-	raw, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read file at path %q\n%w", path, err)
-	}
-
-	block, rest = pem.Decode(raw)
-	if block == nil {
-		return nil, fmt.Errorf("failed to decode PEM data")
-	} else if len(rest) == 0 {
-		// only one cert found, use original path
-		paths = append(paths, path)
-		return paths, nil
-	}
-	for ind := 0; block != nil; ind++ {
-		newCertPath := filepath.Join(certDir, fmt.Sprintf("cert_%d_%s", ind, filepath.Base(path)))
-		if err = os.WriteFile(newCertPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: block.Bytes}), 0777); err != nil {
-			return nil, fmt.Errorf("failed to write extra certficate to file\n%w", err)
-		}
-		paths = append(paths, newCertPath)
-		block, rest = pem.Decode(rest)
-		// openssl x509 < ... ignores whitespace, so does java's keytool
-		rest = bytes.TrimSpace(rest) // ignore any lines containing all spaces
-		if block == nil && len(rest) > 0 {
-			return nil, fmt.Errorf("failed to decode PEM data")
-		}
-	}
-	return paths, nil
+	return truststore.SplitCerts(path, certDir)
 }
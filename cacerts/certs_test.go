@@ -213,6 +213,15 @@ func testCerts(t *testing.T, context spec.G, it spec.S) {
 				ContainSubstring("cert_1_multiple-certs.pem"),
 			))
 		})
+		it("writes the certificates readable but not writable by other users", func() {
+			paths, err := cacerts.SplitCerts(filepath.Join("testdata", "multiple-certs.pem"), dir)
+			Expect(err).NotTo(HaveOccurred())
+			for _, path := range paths {
+				info, err := os.Stat(path)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0644)))
+			}
+		})
 		it("does not split file with 1 cert", func() {
 			paths, err := cacerts.SplitCerts(filepath.Join("testdata", "SecureTrust_CA.pem"), dir)
 			Expect(err).NotTo(HaveOccurred())
@@ -223,6 +232,18 @@ func testCerts(t *testing.T, context spec.G, it spec.S) {
 			_, err := cacerts.SplitCerts(filepath.Join("testdata", "SecureTrust_CA-corrupt.pem"), dir)
 			Expect(err).To(HaveOccurred())
 		})
+		it("does not overwrite certificates split from a file with the same name", func() {
+			first, err := cacerts.SplitCerts(filepath.Join("testdata", "multiple-certs.pem"), dir)
+			Expect(err).NotTo(HaveOccurred())
+			second, err := cacerts.SplitCerts(filepath.Join("testdata", "multiple-certs.pem"), dir)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(second).To(ConsistOf(
+				HaveSuffix("cert_0_multiple-certs.pem.1"),
+				HaveSuffix("cert_1_multiple-certs.pem.1"),
+			))
+			Expect(append(first, second...)).To(HaveLen(4))
+		})
 		it("ignores trailing whitespace in the PEM data", func() {
 			_, err := cacerts.SplitCerts(filepath.Join("testdata", "USERTrust_ECC_CA_extra_whitespace.pem"), dir)
 			Expect(err).NotTo(HaveOccurred())
@@ -19,9 +19,14 @@ package cacerts
 import (
 	"fmt"
 	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/buildpacks/libcnb"
 	"github.com/paketo-buildpacks/libpak"
+	"github.com/paketo-buildpacks/libpak/bard"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
 )
 
 type Detect struct{}
@@ -40,6 +45,15 @@ const (
 // type "ca-certificates" Detect also requires ca-certificates and provides an array of certificate paths in the
 // plan entry metadata.
 //
+// If the application contains a directory of certificates, by default ".ca-certificates" or the directory given by
+// BP_CA_CERTS_APP_DIR, Detect additionally requires ca-certificates with the paths of the files in that directory.
+// PEM encoded certificates provided in BP_CA_CERTS_PEM are required as inline certificates.
+//
+// The certificates of bindings are required with the scope given by the binding's "scope" key and embedded if the
+// binding's "embed" key is true or the binding is listed in BP_EMBED_CERTS_BINDINGS, one plan entry per scope and
+// embedding decision. BP_CA_CERTS_APP_DIR_SCOPE and BP_CA_CERTS_PEM_SCOPE set the scope of the application's and the
+// inline certificates.
+//
 // To prevent default detection, users can set the
 // BP_RUNTIME_CERT_BINDING_DISABLED environment variable to "true" at
 // build-time. This will disable the helper layer, and the buildpack will only
@@ -51,14 +65,70 @@ func (d Detect) Detect(context libcnb.DetectContext) (libcnb.DetectResult, error
 
 	requires := []libcnb.BuildPlanRequire{}
 
+	cr, err := libpak.NewConfigurationResolver(context.Buildpack, nil)
+	if err != nil {
+		return libcnb.DetectResult{}, fmt.Errorf("unable to create configuration resolver\n%w", err)
+	}
+
+	keys, _ := cr.Resolve("BP_CA_CERTS_BINDING_KEYS")
+	filter, err := ParseBindingKeyFilter(keys)
+	if err != nil {
+		return libcnb.DetectResult{}, fmt.Errorf("invalid value for key 'BP_CA_CERTS_BINDING_KEYS'\n%w", err)
+	}
+
 	// If there are CA cert bindings at build time, require PlanEntryCACerts
-	paths := getsCertsFromBindings(context.Platform.Bindings)
-	if len(paths) > 0 {
+	names, _ := cr.Resolve("BP_EMBED_CERTS_BINDINGS")
+	entries, err := bindingPlanEntries(context.Platform.Bindings, filter, strings.FieldsFunc(names, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	}), bard.Logger{})
+	if err != nil {
+		return libcnb.DetectResult{}, err
+	}
+	for _, md := range entries {
 		requires = append(requires, libcnb.BuildPlanRequire{
-			Name: PlanEntryCACerts,
-			Metadata: map[string]interface{}{
-				"paths": paths,
-			},
+			Name:     PlanEntryCACerts,
+			Metadata: md.AsMap(),
+		})
+	}
+
+	// If the application contains CA certs, require PlanEntryCACerts
+	appDir, required := cr.Resolve("BP_CA_CERTS_APP_DIR")
+	if !required {
+		appDir = DefaultAppCertsDir
+	}
+	appPaths, err := getCertsFromApplication(context.Application.Path, appDir, required)
+	if err != nil {
+		return libcnb.DetectResult{}, err
+	}
+	if len(appPaths) > 0 {
+		scope, _ := cr.Resolve("BP_CA_CERTS_APP_DIR_SCOPE")
+		if err := truststore.ValidateScope(scope); err != nil {
+			return libcnb.DetectResult{}, fmt.Errorf("invalid value for key 'BP_CA_CERTS_APP_DIR_SCOPE'\n%w", err)
+		}
+		requires = append(requires, libcnb.BuildPlanRequire{
+			Name:     PlanEntryCACerts,
+			Metadata: PlanEntryMetadata{Paths: appPaths, Scope: scope, Source: SourceApplication}.AsMap(),
+		})
+	}
+
+	// If CA certs are provided inline via the environment, require PlanEntryCACerts
+	if pemData, ok := cr.Resolve("BP_CA_CERTS_PEM"); ok && pemData != "" {
+		scope, _ := cr.Resolve("BP_CA_CERTS_PEM_SCOPE")
+		if err := truststore.ValidateScope(scope); err != nil {
+			return libcnb.DetectResult{}, fmt.Errorf("invalid value for key 'BP_CA_CERTS_PEM_SCOPE'\n%w", err)
+		}
+		md := PlanEntryMetadata{
+			Version:      PlanEntryMetadataVersion,
+			Certificates: []string{pemData},
+			Scope:        scope,
+			Source:       SourceEnvironment,
+		}
+		if err := md.Validate(); err != nil {
+			return libcnb.DetectResult{}, fmt.Errorf("invalid value for key 'BP_CA_CERTS_PEM'\n%w", err)
+		}
+		requires = append(requires, libcnb.BuildPlanRequire{
+			Name:     PlanEntryCACerts,
+			Metadata: md.AsMap(),
 		})
 	}
 
@@ -73,11 +143,6 @@ func (d Detect) Detect(context libcnb.DetectContext) (libcnb.DetectResult, error
 	}
 
 	// If BP_RUNTIME_CERT_BINDING_DISABLED = true, do not enable helper layer.
-	cr, err := libpak.NewConfigurationResolver(context.Buildpack, nil)
-	if err != nil {
-		return libcnb.DetectResult{}, fmt.Errorf("unable to create configuration resolver\n%w", err)
-	}
-
 	if ok, err := d.runtimeCertBindingEnabled(cr); !ok {
 		if err != nil {
 			return libcnb.DetectResult{}, err
@@ -37,6 +37,7 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 	)
 
 	it.Before(func() {
+		ctx.Application.Path = t.TempDir()
 		ctx.Platform.Environment = map[string]string{}
 	})
 
@@ -175,6 +176,146 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 		})
 	})
 
+	context("Binding is a kubernetes.io/tls secret", func() {
+		it.Before(func() {
+			ctx.Platform.Bindings = []libcnb.Binding{
+				{
+					Type:   cacerts.BindingType,
+					Path:   "some-path",
+					Secret: map[string]string{"ca.crt": "", "tls.crt": "", "tls.key": ""},
+				},
+			}
+		})
+
+		it("does not require the private key", func() {
+			result, err := detect.Detect(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Plans[0].Requires[0]).To(Equal(libcnb.BuildPlanRequire{
+				Name: cacerts.PlanEntryCACerts,
+				Metadata: map[string]interface{}{
+					"paths": []string{
+						filepath.Join("some-path", "ca.crt"),
+						filepath.Join("some-path", "tls.crt"),
+					},
+				},
+			}))
+		})
+	})
+
+	context("Bindings have a scope", func() {
+		it.Before(func() {
+			ctx.Platform.Bindings = []libcnb.Binding{
+				{
+					Name:   "proxy",
+					Type:   cacerts.BindingType,
+					Path:   "some-path",
+					Secret: map[string]string{"cert1.pem": "", "scope": "build\n"},
+				},
+				{
+					Name:   "runtime",
+					Type:   cacerts.BindingType,
+					Path:   "other-path",
+					Secret: map[string]string{"cert2.pem": "", "scope": "launch"},
+				},
+				{
+					Name:   "default",
+					Type:   cacerts.BindingType,
+					Path:   "third-path",
+					Secret: map[string]string{"cert3.pem": ""},
+				},
+			}
+		})
+
+		it("requires ca-certificates once per scope", func() {
+			result, err := detect.Detect(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Plans[0].Requires).To(Equal([]libcnb.BuildPlanRequire{
+				{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"paths": []string{filepath.Join("other-path", "cert2.pem")},
+						"scope": cacerts.ScopeLaunch,
+					},
+				},
+				{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"paths": []string{filepath.Join("some-path", "cert1.pem")},
+						"scope": cacerts.ScopeBuild,
+					},
+				},
+				{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"paths": []string{filepath.Join("third-path", "cert3.pem")},
+					},
+				},
+				{Name: cacerts.PlanEntryCACertsHelper},
+			}))
+		})
+
+		it("returns an error for invalid scopes", func() {
+			ctx.Platform.Bindings[0].Secret["scope"] = "runtime"
+
+			_, err := detect.Detect(ctx)
+			Expect(err).To(MatchError(ContainSubstring(`invalid value for key "scope" of binding "proxy"`)))
+		})
+	})
+
+	context("Bindings select embedding", func() {
+		it.Before(func() {
+			ctx.Platform.Bindings = []libcnb.Binding{
+				{
+					Name:   "proxy",
+					Type:   cacerts.BindingType,
+					Path:   "some-path",
+					Secret: map[string]string{"cert1.pem": "", "embed": "false"},
+				},
+				{
+					Name:   "internal",
+					Type:   cacerts.BindingType,
+					Path:   "other-path",
+					Secret: map[string]string{"cert2.pem": ""},
+				},
+			}
+		})
+
+		it.After(func() {
+			os.Unsetenv("BP_EMBED_CERTS_BINDINGS")
+		})
+
+		it("requires ca-certificates once per embedding decision", func() {
+			os.Setenv("BP_EMBED_CERTS_BINDINGS", "internal, proxy")
+
+			result, err := detect.Detect(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Plans[0].Requires).To(Equal([]libcnb.BuildPlanRequire{
+				{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"paths": []string{filepath.Join("other-path", "cert2.pem")},
+						"embed": true,
+					},
+				},
+				{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"paths": []string{filepath.Join("some-path", "cert1.pem")},
+						"embed": false,
+					},
+				},
+				{Name: cacerts.PlanEntryCACertsHelper},
+			}))
+		})
+
+		it("returns an error for invalid values", func() {
+			ctx.Platform.Bindings[0].Secret["embed"] = "sometimes"
+
+			_, err := detect.Detect(ctx)
+			Expect(err).To(MatchError(ContainSubstring(`invalid value for key "embed" of binding "proxy"`)))
+		})
+	})
+
 	context("Binding does not exist with type ca-certificates", func() {
 		var result libcnb.DetectResult
 		it.Before(func() {
@@ -270,4 +411,135 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 			})
 		})
 	})
+
+	context("Application contains CA certificates", func() {
+		it.Before(func() {
+			dir := filepath.Join(ctx.Application.Path, ".ca-certificates")
+			Expect(os.MkdirAll(filepath.Join(dir, "some-dir"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "cert2.pem"), []byte{}, 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "cert1.pem"), []byte{}, 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, ".hidden"), []byte{}, 0644)).To(Succeed())
+		})
+
+		it("requires ca-certificates with the application certificate paths", func() {
+			result, err := detect.Detect(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Plans[0].Requires).To(ContainElement(libcnb.BuildPlanRequire{
+				Name: cacerts.PlanEntryCACerts,
+				Metadata: map[string]interface{}{
+					"paths": []string{
+						filepath.Join(ctx.Application.Path, ".ca-certificates", "cert1.pem"),
+						filepath.Join(ctx.Application.Path, ".ca-certificates", "cert2.pem"),
+					},
+					"source": cacerts.SourceApplication,
+				},
+			}))
+		})
+
+		context("BP_CA_CERTS_APP_DIR is set", func() {
+			it.Before(func() {
+				dir := filepath.Join(ctx.Application.Path, "certs")
+				Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(dir, "cert3.pem"), []byte{}, 0644)).To(Succeed())
+				os.Setenv("BP_CA_CERTS_APP_DIR", "certs")
+			})
+
+			it.After(func() {
+				os.Unsetenv("BP_CA_CERTS_APP_DIR")
+			})
+
+			it("requires ca-certificates with the configured certificate paths", func() {
+				result, err := detect.Detect(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Plans[0].Requires).To(ContainElement(libcnb.BuildPlanRequire{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"paths":  []string{filepath.Join(ctx.Application.Path, "certs", "cert3.pem")},
+						"source": cacerts.SourceApplication,
+					},
+				}))
+			})
+		})
+
+		context("BP_CA_CERTS_APP_DIR does not exist", func() {
+			it.Before(func() {
+				os.Setenv("BP_CA_CERTS_APP_DIR", "missing")
+			})
+
+			it.After(func() {
+				os.Unsetenv("BP_CA_CERTS_APP_DIR")
+			})
+
+			it("returns an error", func() {
+				_, err := detect.Detect(ctx)
+				Expect(err).To(MatchError(ContainSubstring("unable to read application certificate directory")))
+			})
+		})
+	})
+
+	context("BP_CA_CERTS_PEM is set", func() {
+		var pemData string
+
+		it.Before(func() {
+			raw, err := os.ReadFile(filepath.Join("testdata", "multiple-certs.pem"))
+			Expect(err).NotTo(HaveOccurred())
+			pemData = string(raw)
+			os.Setenv("BP_CA_CERTS_PEM", pemData)
+		})
+
+		it.After(func() {
+			os.Unsetenv("BP_CA_CERTS_PEM")
+		})
+
+		it("requires ca-certificates with inline certificates", func() {
+			result, err := detect.Detect(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Plans[0].Requires).To(ContainElement(libcnb.BuildPlanRequire{
+				Name: cacerts.PlanEntryCACerts,
+				Metadata: map[string]interface{}{
+					"certificates": []string{pemData},
+					"source":       cacerts.SourceEnvironment,
+				},
+			}))
+		})
+
+		context("BP_CA_CERTS_PEM_SCOPE is set", func() {
+			it.After(func() {
+				os.Unsetenv("BP_CA_CERTS_PEM_SCOPE")
+			})
+
+			it("requires ca-certificates with the scope", func() {
+				os.Setenv("BP_CA_CERTS_PEM_SCOPE", "build")
+
+				result, err := detect.Detect(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Plans[0].Requires).To(ContainElement(libcnb.BuildPlanRequire{
+					Name: cacerts.PlanEntryCACerts,
+					Metadata: map[string]interface{}{
+						"certificates": []string{pemData},
+						"scope":        cacerts.ScopeBuild,
+						"source":       cacerts.SourceEnvironment,
+					},
+				}))
+			})
+
+			it("returns an error for invalid scopes", func() {
+				os.Setenv("BP_CA_CERTS_PEM_SCOPE", "runtime")
+
+				_, err := detect.Detect(ctx)
+				Expect(err).To(MatchError(ContainSubstring("invalid value for key 'BP_CA_CERTS_PEM_SCOPE'")))
+			})
+		})
+
+		context("value does not contain PEM data", func() {
+			it.Before(func() {
+				os.Setenv("BP_CA_CERTS_PEM", "not-pem")
+			})
+
+			it("returns an error", func() {
+				_, err := detect.Detect(ctx)
+				Expect(err).To(MatchError(ContainSubstring("invalid value for key 'BP_CA_CERTS_PEM'")))
+			})
+		})
+	})
 }
@@ -0,0 +1,221 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+const (
+	// EmbeddedCertsFile is the file, relative to the layer, recording the certificates embedded at build time.
+	EmbeddedCertsFile = "embedded-certs.json"
+
+	// EnvEmbeddedCerts is set in the launch environment to the path of the EmbeddedCertsFile.
+	EnvEmbeddedCerts = "BPL_CA_CERTS_EMBEDDED"
+)
+
+// EmbeddedCert is a certificate embedded into the application image.
+type EmbeddedCert struct {
+	Fingerprint string `json:"fingerprint"`
+	Subject     string `json:"subject"`
+
+	// Binding is the name of the binding that provided the certificate at build time, empty for other sources.
+	Binding string `json:"binding,omitempty"`
+
+	// Path is the path of the embedded copy of the certificate.
+	Path string `json:"path"`
+
+	// ProcessType is the process type that trusts the certificate, empty if it is trusted by all process types.
+	ProcessType string `json:"process-type,omitempty"`
+}
+
+// EmbeddedCerts records the certificates embedded into the application image so that the helper can reconcile them
+// with the certificates of runtime bindings.
+type EmbeddedCerts struct {
+	// Dir is the directory of hash links of the embedded certificates that is added to SSL_CERT_DIR.
+	Dir string `json:"dir"`
+
+	// ProcessDirs are the directories of hash links of the certificates of each process type that are added to
+	// SSL_CERT_DIR of the process type.
+	ProcessDirs map[string]string `json:"process-dirs,omitempty"`
+
+	Certificates []EmbeddedCert `json:"certificates"`
+}
+
+// ReadEmbeddedCerts reads the EmbeddedCerts recorded at path.
+func ReadEmbeddedCerts(path string) (EmbeddedCerts, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return EmbeddedCerts{}, fmt.Errorf("failed to read %q\n%w", path, err)
+	}
+	var e EmbeddedCerts
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return EmbeddedCerts{}, fmt.Errorf("failed to decode %q\n%w", path, err)
+	}
+	return e, nil
+}
+
+// Write writes the record to path.
+func (e EmbeddedCerts) Write(path string) error {
+	raw, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode embedded certificates\n%w", err)
+	}
+	if err := os.WriteFile(path, raw, truststore.FileMode); err != nil {
+		return fmt.Errorf("failed to write %q\n%w", path, err)
+	}
+	return nil
+}
+
+// reconcile reconciles the certificates loaded at runtime into ts with the certificates embedded at build time for
+// all process types or for processType, as recorded in the file given by EnvEmbeddedCerts. It returns the directories
+// of the embedded certificates that must be removed from SSL_CERT_DIR.
+//
+// If a runtime binding has the same name as the binding that provided embedded certificates but different
+// certificates, it overrides them: the embedded directories are dropped and the remaining embedded certificates are
+// added to ts instead. They were verified when they were embedded, so the integrity of their copies is not checked
+// again. Otherwise certificates that are already embedded are removed from ts.
+func (e *ExecD) reconcile(ts *truststore.Truststore, processType string) ([]string, error) {
+	path := e.GetEnv(EnvEmbeddedCerts)
+	if path == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	embedded, err := ReadEmbeddedCerts(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var trusted []EmbeddedCert
+	for _, c := range embedded.Certificates {
+		if c.ProcessType == "" || c.ProcessType == processType {
+			trusted = append(trusted, c)
+		}
+	}
+
+	runtime := map[string][]string{}
+	for _, c := range ts.Certificates() {
+		for _, o := range c.Origins {
+			if o.Kind == truststore.OriginBinding && !slices.Contains(runtime[o.Name], c.Fingerprint) {
+				runtime[o.Name] = append(runtime[o.Name], c.Fingerprint)
+			}
+		}
+	}
+	built := map[string][]string{}
+	for _, c := range trusted {
+		if c.Binding != "" && !slices.Contains(built[c.Binding], c.Fingerprint) {
+			built[c.Binding] = append(built[c.Binding], c.Fingerprint)
+		}
+	}
+
+	overridden := map[string]bool{}
+	for binding, fingerprints := range built {
+		if r, ok := runtime[binding]; ok && !sameElements(r, fingerprints) {
+			e.Logger.Bodyf("Runtime binding %q overrides its %d embedded CA certificate(s)", binding, len(fingerprints))
+			overridden[binding] = true
+		}
+	}
+
+	if len(overridden) > 0 {
+		for _, c := range trusted {
+			if overridden[c.Binding] {
+				continue
+			}
+			if err := ts.AddVerifiedFile(c.Path, truststore.Origin{Kind: truststore.OriginFile, Name: c.Binding}); err != nil {
+				return nil, fmt.Errorf("failed to load embedded certificate\n%w", err)
+			}
+		}
+		drop := []string{embedded.Dir}
+		if dir, ok := embedded.ProcessDirs[processType]; ok && processType != "" {
+			drop = append(drop, dir)
+		}
+		return drop, nil
+	}
+
+	for _, c := range ts.Certificates() {
+		if slices.ContainsFunc(trusted, func(ec EmbeddedCert) bool { return ec.Fingerprint == c.Fingerprint }) {
+			ts.Remove(c.Fingerprint)
+			e.Logger.Bodyf("Skipping CA certificate %q, already embedded", c.Subject)
+			e.emit(truststore.EventSkipped, c.Certificate, c.Origins[0], "already embedded")
+		}
+	}
+	return nil, nil
+}
+
+// embeddedCerts returns the record of the embedded certificates in CertPaths and ProcessCertPaths of the layer at
+// layerPath and the bindings that provided them.
+func (l TrustedCACerts) embeddedCerts(layerPath string) (EmbeddedCerts, error) {
+	e := EmbeddedCerts{Dir: filepath.Join(layerPath, CACertsDir), Certificates: []EmbeddedCert{}}
+	add := func(paths []string, processType string) error {
+		for _, path := range paths {
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read file at path %q\n%w", path, err)
+			}
+			certs, err := truststore.DecodeCertificates(raw)
+			if err != nil {
+				return fmt.Errorf("failed to decode certificates from file at path %q\n%w", path, err)
+			}
+			for _, c := range certs {
+				e.Certificates = append(e.Certificates, EmbeddedCert{
+					Fingerprint: truststore.Fingerprint(c),
+					Subject:     c.Subject.String(),
+					Binding:     l.CertBindings[path],
+					Path:        filepath.Clean(path),
+					ProcessType: processType,
+				})
+			}
+		}
+		return nil
+	}
+
+	if err := add(l.CertPaths, ""); err != nil {
+		return EmbeddedCerts{}, err
+	}
+
+	var processTypes []string
+	for processType := range l.ProcessCertPaths {
+		processTypes = append(processTypes, processType)
+	}
+	sort.Strings(processTypes)
+	for _, processType := range processTypes {
+		if e.ProcessDirs == nil {
+			e.ProcessDirs = map[string]string{}
+		}
+		e.ProcessDirs[processType] = filepath.Join(layerPath, ProcessCertsDir, processType)
+		if err := add(l.ProcessCertPaths[processType], processType); err != nil {
+			return EmbeddedCerts{}, err
+		}
+	}
+	return e, nil
+}
+
+func sameElements(a []string, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+const (
+	// LogFormatText is the default log format of the helper.
+	LogFormatText = "text"
+
+	// LogFormatJSON makes the helper log one JSON event per line instead of text.
+	LogFormatJSON = "json"
+
+	// EventSummary is the event logged by the helper after all certificates have been processed.
+	EventSummary = "summary"
+
+	// ExpiryWarningPeriod is the period before their expiry in which added certificates are counted as expiring in
+	// the summary event.
+	ExpiryWarningPeriod = 30 * 24 * time.Hour
+)
+
+// SummaryEvent is logged by the helper after all certificates have been processed.
+type SummaryEvent struct {
+	Event    string `json:"event"`
+	Added    int    `json:"added"`
+	Skipped  int    `json:"skipped"`
+	Rejected int    `json:"rejected"`
+
+	// Expiring is the number of added certificates that expire within the ExpiryWarningPeriod.
+	Expiring int `json:"expiring"`
+
+	// Error is the error that stopped the helper, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// eventLog writes events as JSON lines and counts them for the summary event.
+type eventLog struct {
+	encoder *json.Encoder
+	summary SummaryEvent
+	now     func() time.Time
+}
+
+func newEventLog(w io.Writer) *eventLog {
+	if w == nil {
+		w = io.Discard
+	}
+	return &eventLog{encoder: json.NewEncoder(w), summary: SummaryEvent{Event: EventSummary}, now: time.Now}
+}
+
+func (l *eventLog) record(event truststore.Event) {
+	switch event.Event {
+	case truststore.EventAdded:
+		l.summary.Added++
+		if notAfter, err := time.Parse(time.RFC3339, event.NotAfter); err == nil && notAfter.Before(l.now().Add(ExpiryWarningPeriod)) {
+			l.summary.Expiring++
+		}
+	case truststore.EventSkipped:
+		l.summary.Skipped++
+	case truststore.EventRejected:
+		l.summary.Rejected++
+	}
+	_ = l.encoder.Encode(event)
+}
+
+func (l *eventLog) close(err error) {
+	if err != nil {
+		l.summary.Error = strings.ReplaceAll(err.Error(), "\n", ": ")
+	}
+	_ = l.encoder.Encode(l.summary)
+}
+
+// emit passes an event about cert from origin to the event log, if the helper logs events.
+func (e *ExecD) emit(event string, cert *x509.Certificate, origin truststore.Origin, reason string) {
+	if e.events != nil {
+		e.events(truststore.NewEvent(event, cert, origin, reason))
+	}
+}
+
+// emitAdded passes the event about the added certificate c to the event log, if the helper logs events.
+func (e *ExecD) emitAdded(c truststore.Certificate) {
+	if e.events != nil {
+		e.events(c.AddedEvent())
+	}
+}
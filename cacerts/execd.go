@@ -20,11 +20,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/buildpacks/libcnb"
 
 	"github.com/paketo-buildpacks/libpak/bard"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
 )
 
 const (
@@ -36,51 +40,320 @@ const (
 type ExecD struct {
 	Logger            bard.Logger
 	Bindings          libcnb.Bindings
+	CacheDir          string
 	GenerateHashLinks func(dir string, certPaths []string) error
 	GetEnv            func(key string) string
+	StartWatcher      func(certDir string) error
+
+	// CertDirsInUse returns the directories in SSL_CERT_DIR of all running processes. If it is not set, or returns an
+	// error, unused certificate directories are not removed from the cache.
+	CertDirsInUse func() (map[string]bool, error)
+
+	// events receives the events about certificates if BPL_CA_CERTS_LOG_FORMAT is json.
+	events func(truststore.Event)
 }
 
 func NewExecD(bindings libcnb.Bindings) *ExecD {
 	return &ExecD{
 		Bindings:          bindings,
-		GenerateHashLinks: GenerateHashLinks,
+		CacheDir:          DefaultCacheDir(),
+		GenerateHashLinks: truststore.SyncHashLinks,
 		GetEnv:            os.Getenv,
+		StartWatcher:      StartWatcherProcess,
+		CertDirsInUse:     ProcessCertDirs,
 	}
 }
 
-// Execute adds certificates from bindings of type "ca-certificates" to the system truststore at launch time.
+// Execute adds certificates from bindings of type "ca-certificates" and PEM encoded certificates provided in
+// BPL_CA_CERTS_PEM to the system truststore at launch time. Certificates are checked against the policy selected by
+// BPL_CA_CERTS_POLICY. If BPL_CA_CERTS_ALLOWED_FINGERPRINTS or BPL_CA_CERTS_SIGNING_KEYS is set, certificates that are
+// neither allowed nor signed are refused. Bindings with scope "build" are skipped.
+//
+// BPL_CA_CERTS_PATHS is a list of additional files and directories containing certificates, separated by the OS
+// path list separator. All non-hidden files in the directories are loaded. If BPL_CA_CERTS_DISABLED is true, Execute
+// does nothing.
+//
+// BPL_CA_CERTS_PROCESS_TYPES maps binding names, BPL_CA_CERTS_PATHS and BPL_CA_CERTS_PEM to process types, see
+// ParseProcessTypes. Mapped sources are skipped unless they are mapped to the current process type, given by
+// BPL_CA_CERTS_PROCESS_TYPE or, if it is not set, CNB_PROCESS_TYPE.
+//
+// If BPL_CA_CERTS_WATCH is true, Execute starts a background process that keeps the truststore in sync with the
+// bindings while the application is running.
+//
+// If BPL_CA_CERTS_LOG_FORMAT is json, Execute logs a JSON event for each certificate it adds, skips or rejects,
+// followed by a SummaryEvent, instead of text.
 func (e *ExecD) Execute() (map[string]string, error) {
+	var env map[string]string
+	err := e.logEvents(func(e *ExecD) error {
+		var err error
+		env, err = e.execute()
+		return err
+	})
+	return env, err
+}
+
+// logEvents calls run with e or, if BPL_CA_CERTS_LOG_FORMAT is json, with a copy of e that logs a JSON event for each
+// certificate instead of text, followed by a SummaryEvent including the error returned by run.
+func (e *ExecD) logEvents(run func(e *ExecD) error) error {
+	switch format := e.GetEnv("BPL_CA_CERTS_LOG_FORMAT"); format {
+	case "", LogFormatText:
+		return run(e)
+	case LogFormatJSON:
+		log := newEventLog(e.Logger.InfoWriter())
+		execd := *e
+		execd.Logger = bard.Logger{}
+		execd.events = log.record
+		err := run(&execd)
+		log.close(err)
+		return err
+	default:
+		return fmt.Errorf("invalid value '%s' for key 'BPL_CA_CERTS_LOG_FORMAT': expected one of [%s, %s]",
+			format, LogFormatText, LogFormatJSON)
+	}
+}
+
+func (e *ExecD) execute() (map[string]string, error) {
 	env := map[string]string{}
-	var splitPaths []string
 
-	paths := getsCertsFromBindings(e.Bindings)
-	if len(paths) == 0 {
+	if disabled, err := e.resolveBool("BPL_CA_CERTS_DISABLED"); err != nil {
+		return nil, err
+	} else if disabled {
+		e.Logger.Infof("Not adding CA certificates, BPL_CA_CERTS_DISABLED is true")
 		return env, nil
 	}
-	certDir, err := os.MkdirTemp("", "ca-certificates")
+
+	watch, err := e.resolveBool("BPL_CA_CERTS_WATCH")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp dir\n%w", err)
+		return nil, err
 	}
-	for _, p := range paths {
-		if extraPaths, err := SplitCerts(p, certDir); err != nil {
-			return nil, fmt.Errorf("failed to split certificates at path %s \n%w", p, err)
-		} else {
-			splitPaths = append(splitPaths, extraPaths...)
+
+	ts, err := e.truststore(false)
+	if err != nil {
+		return nil, err
+	}
+	drop, err := e.reconcile(ts, e.processType())
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range ts.Certificates() {
+		e.emitAdded(c)
+	}
+	if ts.Len() == 0 && !watch {
+		return env, nil
+	}
+
+	cacheDir, err := e.cacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var certDir string
+	if watch {
+		// The watcher updates its directory in place, it is not shared with other processes
+		if certDir, err = truststore.MkdirTemp(cacheDir, "watch-"); err != nil {
+			return nil, fmt.Errorf("failed to create temp dir\n%w", err)
+		}
+		if err := e.writeCertDir(ts, certDir); err != nil {
+			return nil, err
 		}
+	} else if certDir, err = e.cachedCertDir(ts, cacheDir); err != nil {
+		return nil, err
+	}
+	if err := e.removeUnusedCertDirs(cacheDir, certDir); err != nil {
+		return nil, err
 	}
 
-	if err := e.GenerateHashLinks(certDir, splitPaths); err != nil {
-		return nil, fmt.Errorf("failed to generate CA certficate symlinks\n%w", err)
+	if watch {
+		if err := e.StartWatcher(certDir); err != nil {
+			return nil, fmt.Errorf("failed to start watching ca-certificates bindings\n%w", err)
+		}
+		e.Logger.Infof("Watching ca-certificates bindings for changes")
 	}
-	e.Logger.Infof("Added %d additional CA certificate(s) to system truststore", len(splitPaths))
 
-	if v := e.GetEnv(EnvCAPath); v == "" {
-		env[EnvCAPath] = certDir
-	} else {
-		env[EnvCAPath] = strings.Join([]string{v, certDir}, string(filepath.ListSeparator))
+	var dirs []string
+	for _, dir := range filepath.SplitList(e.GetEnv(EnvCAPath)) {
+		if !slices.Contains(drop, dir) {
+			dirs = append(dirs, dir)
+		}
 	}
+	env[EnvCAPath] = strings.Join(append(dirs, certDir), string(filepath.ListSeparator))
 	if v := e.GetEnv(EnvCAFile); v == "" {
 		env[EnvCAFile] = DefaultCAFile
 	}
 	return env, nil
 }
+
+// processType returns the current process type, given by BPL_CA_CERTS_PROCESS_TYPE or, if it is not set,
+// CNB_PROCESS_TYPE.
+func (e *ExecD) processType() string {
+	if processType := e.GetEnv(EnvProcessType); processType != "" {
+		return processType
+	}
+	return e.GetEnv(EnvCNBProcessType)
+}
+
+// integrity returns the truststore.Integrity configured by BPL_CA_CERTS_ALLOWED_FINGERPRINTS and
+// BPL_CA_CERTS_SIGNING_KEYS and the one pinned at build time, see PinnedIntegrity.
+func (e *ExecD) integrity() (truststore.Integrity, truststore.Integrity, error) {
+	integrity, err := newIntegrity(e.GetEnv, "BPL_CA_CERTS_ALLOWED_FINGERPRINTS", "BPL_CA_CERTS_SIGNING_KEYS")
+	if err != nil {
+		return truststore.Integrity{}, truststore.Integrity{}, err
+	}
+	pinned, err := newIntegrity(e.GetEnv, EnvPinnedFingerprints, EnvPinnedSigningKeys)
+	if err != nil {
+		return truststore.Integrity{}, truststore.Integrity{}, err
+	}
+	return integrity, pinned, nil
+}
+
+// truststore loads the certificates from bindings and BPL_CA_CERTS_PEM that should be trusted at launch time. If audit
+// is true, certificates rejected by the policy or the integrity configuration are loaded too, see truststore.Audit.
+func (e *ExecD) truststore(audit bool) (*truststore.Truststore, error) {
+	filter, err := ParseBindingKeyFilter(e.GetEnv("BPL_CA_CERTS_BINDING_KEYS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for key 'BPL_CA_CERTS_BINDING_KEYS'\n%w", err)
+	}
+
+	policy, err := truststore.LookupPolicy(e.GetEnv("BPL_CA_CERTS_POLICY"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for key 'BPL_CA_CERTS_POLICY'\n%w", err)
+	}
+
+	integrity, pinned, err := e.integrity()
+	if err != nil {
+		return nil, err
+	}
+
+	processTypes, err := ParseProcessTypes(e.GetEnv("BPL_CA_CERTS_PROCESS_TYPES"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for key 'BPL_CA_CERTS_PROCESS_TYPES'\n%w", err)
+	}
+	processType := e.processType()
+
+	ts := truststore.New()
+	ts.Logger = e.Logger
+	ts.Policy = policy
+	ts.Integrity = integrity
+	ts.Pinned = pinned
+	ts.Events = e.events
+	ts.Audit = audit
+	skipped := map[string]bool{}
+	for _, f := range truststore.BindingFiles(e.Bindings, filter, e.Logger) {
+		if err := truststore.ValidateScope(f.Scope); err != nil {
+			return nil, fmt.Errorf("invalid value for key %q of binding %q\n%w", truststore.BindingKeyScope, f.Binding, err)
+		}
+		origin := truststore.Origin{Kind: truststore.OriginBinding, Name: f.Binding, Key: f.Key, Path: f.Path}
+		if f.Scope == truststore.ScopeBuild {
+			e.emit(truststore.EventSkipped, nil, origin, fmt.Sprintf("binding has scope %q", f.Scope))
+			if !skipped[f.Binding] {
+				e.Logger.Bodyf("Skipping binding %q with scope %q", f.Binding, f.Scope)
+				skipped[f.Binding] = true
+			}
+			continue
+		}
+		if !processTypes.Trusts(f.Binding, processType) {
+			e.emit(truststore.EventSkipped, nil, origin, fmt.Sprintf("binding is not mapped to process type %q", processType))
+			if !skipped[f.Binding] {
+				e.Logger.Bodyf("Skipping binding %q not mapped to process type %q", f.Binding, processType)
+				skipped[f.Binding] = true
+			}
+			continue
+		}
+		if err := ts.AddBindingFile(f); err != nil {
+			return nil, fmt.Errorf("failed to load certificates from bindings\n%w", err)
+		}
+	}
+	for _, p := range filepath.SplitList(e.GetEnv("BPL_CA_CERTS_PATHS")) {
+		if p == "" {
+			continue
+		}
+		if !processTypes.Trusts(SourcePaths, processType) {
+			e.Logger.Bodyf("Skipping %s not mapped to process type %q", SourcePaths, processType)
+			e.emit(truststore.EventSkipped, nil, truststore.Origin{Kind: truststore.OriginFile, Name: SourcePaths},
+				fmt.Sprintf("%s is not mapped to process type %q", SourcePaths, processType))
+			break
+		}
+		if err := e.addPath(ts, p); err != nil {
+			return nil, fmt.Errorf("failed to load certificates from BPL_CA_CERTS_PATHS\n%w", err)
+		}
+	}
+	if inline := e.GetEnv("BPL_CA_CERTS_PEM"); inline != "" && !processTypes.Trusts(SourceInline, processType) {
+		e.Logger.Bodyf("Skipping %s not mapped to process type %q", SourceInline, processType)
+		e.emit(truststore.EventSkipped, nil, truststore.Origin{Kind: truststore.OriginPEM, Name: SourceInline},
+			fmt.Sprintf("%s is not mapped to process type %q", SourceInline, processType))
+	} else if inline != "" {
+		if err := ts.AddPEM([]byte(inline), truststore.Origin{Kind: truststore.OriginPEM, Name: SourceInline}); err != nil {
+			return nil, fmt.Errorf("failed to load certificates from BPL_CA_CERTS_PEM\n%w", err)
+		}
+	}
+	return ts, nil
+}
+
+// writeCertDir writes the certificates of ts that are not in a file of their own to a new directory in certDir and
+// generates the hash links in certDir.
+func (e *ExecD) writeCertDir(ts *truststore.Truststore, certDir string) error {
+	splitDir, err := truststore.MkdirTemp(certDir, "..split-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir\n%w", err)
+	}
+	splitPaths, err := ts.WriteFiles(splitDir)
+	if err != nil {
+		return err
+	}
+
+	if err := e.GenerateHashLinks(certDir, splitPaths); err != nil {
+		return fmt.Errorf("failed to generate CA certficate symlinks\n%w", err)
+	}
+	e.Logger.Infof("Added %d additional CA certificate(s) to system truststore", len(splitPaths))
+	return nil
+}
+
+// addPath adds the certificates in the file at path or, if path is a directory, in its non-hidden files.
+func (e *ExecD) addPath(ts *truststore.Truststore, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("unable to stat %q\n%w", path, err)
+	}
+
+	paths := []string{path}
+	if info.IsDir() {
+		if paths, err = getCertsFromApplication("", path, true); err != nil {
+			return err
+		}
+	}
+	for _, p := range paths {
+		if err := ts.AddFile(p, truststore.Origin{Kind: truststore.OriginFile, Name: SourcePaths}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *ExecD) resolveBool(key string) (bool, error) {
+	val := e.GetEnv(key)
+	if val == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf(
+			"invalid value '%s' for key '%s': expected one of [1, t, T, TRUE, true, True, 0, f, F, FALSE, false, False]",
+			val,
+			key,
+		)
+	}
+	return b, nil
+}
+
+// newIntegrity creates the truststore.Integrity configured by the allowed fingerprints and signing keys read with get.
+func newIntegrity(get func(key string) string, fingerprintsKey string, signingKeysKey string) (truststore.Integrity, error) {
+	fingerprints, err := truststore.ParseFingerprints(get(fingerprintsKey))
+	if err != nil {
+		return truststore.Integrity{}, fmt.Errorf("invalid value for key '%s'\n%w", fingerprintsKey, err)
+	}
+	keys, err := truststore.ParseSigningKeys(get(signingKeysKey))
+	if err != nil {
+		return truststore.Integrity{}, fmt.Errorf("invalid value for key '%s'\n%w", signingKeysKey, err)
+	}
+	return truststore.Integrity{Fingerprints: fingerprints, SigningKeys: keys}, nil
+}
@@ -17,15 +17,23 @@
 package cacerts_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/buildpacks/libcnb"
 	. "github.com/onsi/gomega"
+	"github.com/paketo-buildpacks/libpak/bard"
 	"github.com/sclevine/spec"
 
 	"github.com/paketo-buildpacks/ca-certificates/v3/cacerts"
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
 )
 
 func testExecD(t *testing.T, context spec.G, it spec.S) {
@@ -42,6 +50,7 @@ func testExecD(t *testing.T, context spec.G, it spec.S) {
 	it.Before(func() {
 		env = map[string]string{}
 		execd = &cacerts.ExecD{
+			CacheDir: t.TempDir(),
 			GenerateHashLinks: func(dir string, paths []string) error {
 				certDir = dir
 				certPaths = paths
@@ -104,7 +113,6 @@ func testExecD(t *testing.T, context spec.G, it spec.S) {
 				Expect(called).To(Equal(1))
 				Expect(certPaths).To(ConsistOf(
 					ContainSubstring(filepath.Join("testdata", "SecureTrust_CA.pem")),
-					ContainSubstring(filepath.Join("testdata", "SecureTrust_CA_Duplicate.pem")),
 					ContainSubstring(filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem")),
 				))
 				Expect(envFile["SSL_CERT_DIR"]).To(Equal(certDir))
@@ -121,7 +129,6 @@ func testExecD(t *testing.T, context spec.G, it spec.S) {
 				Expect(err).NotTo(HaveOccurred())
 				Expect(certPaths).To(ConsistOf(
 					ContainSubstring(filepath.Join("testdata", "SecureTrust_CA.pem")),
-					ContainSubstring(filepath.Join("testdata", "SecureTrust_CA_Duplicate.pem")),
 					ContainSubstring(filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem")),
 				))
 				Expect(envFile["SSL_CERT_DIR"]).To(Equal("some-dir" + string(os.PathListSeparator) + certDir))
@@ -129,6 +136,217 @@ func testExecD(t *testing.T, context spec.G, it spec.S) {
 		})
 	})
 
+	context("cache", func() {
+		it.Before(func() {
+			execd.Bindings = []libcnb.Binding{
+				{
+					Type:   "ca-certificates",
+					Path:   "testdata",
+					Secret: map[string]string{"SecureTrust_CA.pem": ""},
+				},
+			}
+		})
+
+		it("reuses the directory if the certificates have not changed", func() {
+			first, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			second, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(called).To(Equal(1))
+			Expect(second["SSL_CERT_DIR"]).To(Equal(first["SSL_CERT_DIR"]))
+			Expect(filepath.Dir(first["SSL_CERT_DIR"])).To(Equal(execd.CacheDir))
+		})
+
+		context("unused directories", func() {
+			var (
+				inUse map[string]bool
+				old   = time.Now().Add(-2 * cacerts.UnusedCertDirAge)
+			)
+
+			it.Before(func() {
+				inUse = map[string]bool{}
+				execd.CertDirsInUse = func() (map[string]bool, error) {
+					return inUse, nil
+				}
+			})
+
+			it("keeps recently used directories", func() {
+				first, err := execd.Execute()
+				Expect(err).NotTo(HaveOccurred())
+
+				execd.Bindings[0].Secret = map[string]string{"Go_Daddy_Class_2_CA.pem": ""}
+				second, err := execd.Execute()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(called).To(Equal(2))
+				Expect(second["SSL_CERT_DIR"]).NotTo(Equal(first["SSL_CERT_DIR"]))
+				Expect(first["SSL_CERT_DIR"]).To(BeADirectory())
+				Expect(second["SSL_CERT_DIR"]).To(BeADirectory())
+			})
+
+			it("removes directories that have not been used for a while", func() {
+				first, err := execd.Execute()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.Chtimes(filepath.Join(first["SSL_CERT_DIR"], ".complete"), old, old)).To(Succeed())
+
+				execd.Bindings[0].Secret = map[string]string{"Go_Daddy_Class_2_CA.pem": ""}
+				second, err := execd.Execute()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(first["SSL_CERT_DIR"]).NotTo(BeADirectory())
+				Expect(second["SSL_CERT_DIR"]).To(BeADirectory())
+			})
+
+			it("keeps directories used by running processes", func() {
+				first, err := execd.Execute()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.Chtimes(filepath.Join(first["SSL_CERT_DIR"], ".complete"), old, old)).To(Succeed())
+				inUse[first["SSL_CERT_DIR"]] = true
+
+				execd.Bindings[0].Secret = map[string]string{"Go_Daddy_Class_2_CA.pem": ""}
+				_, err = execd.Execute()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(first["SSL_CERT_DIR"]).To(BeADirectory())
+			})
+
+			it("keeps all directories if the directories in use are unknown", func() {
+				execd.CertDirsInUse = func() (map[string]bool, error) {
+					return nil, fmt.Errorf("test error")
+				}
+				first, err := execd.Execute()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.Chtimes(filepath.Join(first["SSL_CERT_DIR"], ".complete"), old, old)).To(Succeed())
+
+				execd.Bindings[0].Secret = map[string]string{"Go_Daddy_Class_2_CA.pem": ""}
+				_, err = execd.Execute()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(first["SSL_CERT_DIR"]).To(BeADirectory())
+			})
+
+			it("removes directories of watchers that are no longer running", func() {
+				watchDir := filepath.Join(execd.CacheDir, "watch-1234")
+				Expect(os.Mkdir(watchDir, 0755)).To(Succeed())
+				Expect(os.Chtimes(watchDir, old, old)).To(Succeed())
+				otherDir := filepath.Join(execd.CacheDir, "other")
+				Expect(os.Mkdir(otherDir, 0755)).To(Succeed())
+				Expect(os.Chtimes(otherDir, old, old)).To(Succeed())
+
+				_, err := execd.Execute()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(watchDir).NotTo(BeADirectory())
+				Expect(otherDir).To(BeADirectory())
+			})
+		})
+
+		it("removes split certificates of processes that did not finish populating the directory", func() {
+			execd.Bindings[0].Secret = map[string]string{"multiple-certs.pem": ""}
+			first, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.Remove(filepath.Join(first["SSL_CERT_DIR"], ".complete"))).To(Succeed())
+			stale := filepath.Join(first["SSL_CERT_DIR"], "..split-stale")
+			Expect(os.Mkdir(stale, 0755)).To(Succeed())
+
+			second, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(called).To(Equal(2))
+			Expect(second["SSL_CERT_DIR"]).To(Equal(first["SSL_CERT_DIR"]))
+			Expect(stale).NotTo(BeADirectory())
+			Expect(filepath.Join(first["SSL_CERT_DIR"], ".complete")).To(BeARegularFile())
+		})
+
+		context("cache directory is not private", func() {
+			it.Before(func() {
+				t.Setenv("TMPDIR", t.TempDir())
+			})
+
+			it("uses a new directory if the cache directory is writable by other users", func() {
+				Expect(os.Chmod(execd.CacheDir, 0777)).To(Succeed())
+
+				envFile, err := execd.Execute()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(filepath.Dir(envFile["SSL_CERT_DIR"])).NotTo(Equal(execd.CacheDir))
+				Expect(envFile["SSL_CERT_DIR"]).To(HavePrefix(os.TempDir()))
+			})
+
+			it("uses a new directory if the cache directory is a symbolic link", func() {
+				link := filepath.Join(t.TempDir(), "cache")
+				Expect(os.Symlink(execd.CacheDir, link)).To(Succeed())
+				execd.CacheDir = link
+
+				envFile, err := execd.Execute()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(envFile["SSL_CERT_DIR"]).NotTo(HavePrefix(link))
+				Expect(envFile["SSL_CERT_DIR"]).To(HavePrefix(os.TempDir()))
+			})
+		})
+
+		it("makes the certificates readable by other users", func() {
+			execd.Bindings[0].Secret = map[string]string{"multiple-certs.pem": ""}
+
+			envFile, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+
+			info, err := os.Stat(envFile["SSL_CERT_DIR"])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+
+			Expect(certPaths).To(HaveLen(2))
+			for _, path := range certPaths {
+				info, err := os.Stat(filepath.Dir(path))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+
+				info, err = os.Stat(path)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0644)))
+			}
+		})
+
+		it("uses BPL_CA_CERTS_CACHE_DIR", func() {
+			env["BPL_CA_CERTS_CACHE_DIR"] = filepath.Join(t.TempDir(), "cache")
+
+			envFile, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(filepath.Dir(envFile["SSL_CERT_DIR"])).To(Equal(env["BPL_CA_CERTS_CACHE_DIR"]))
+		})
+
+		it("creates the same links in processes starting at the same time", func() {
+			dir, err := filepath.Abs("testdata")
+			Expect(err).NotTo(HaveOccurred())
+			execd.Bindings[0].Path = dir
+			execd.Bindings[0].Secret["multiple-certs.pem"] = ""
+			execd.GenerateHashLinks = truststore.SyncHashLinks
+
+			var wg sync.WaitGroup
+			dirs := make([]string, 8)
+			for i := range dirs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					e := *execd
+					envFile, err := e.Execute()
+					Expect(err).NotTo(HaveOccurred())
+					dirs[i] = envFile["SSL_CERT_DIR"]
+				}(i)
+			}
+			wg.Wait()
+
+			for _, dir := range dirs {
+				Expect(dir).To(Equal(dirs[0]))
+			}
+			for _, link := range []string{"f39fc864.0", "f081611a.0"} {
+				target, err := filepath.EvalSymlinks(filepath.Join(dirs[0], link))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(target).To(BeARegularFile())
+			}
+		})
+	})
+
 	context("Binding does not exist with type ca-certificates", func() {
 		it("does nothing", func() {
 			env, err := execd.Execute()
@@ -136,4 +354,732 @@ func testExecD(t *testing.T, context spec.G, it spec.S) {
 			Expect(env).To(BeEmpty())
 		})
 	})
+
+	context("BPL_CA_CERTS_PEM is set", func() {
+		it.Before(func() {
+			raw, err := os.ReadFile(filepath.Join("testdata", "multiple-certs.pem"))
+			Expect(err).NotTo(HaveOccurred())
+			env["BPL_CA_CERTS_PEM"] = string(raw)
+		})
+
+		it("adds the inline certificates", func() {
+			envFile, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(called).To(Equal(1))
+			Expect(certPaths).To(ConsistOf(
+				And(HavePrefix(certDir), HaveSuffix("cert_0_BPL_CA_CERTS_PEM.pem")),
+				And(HavePrefix(certDir), HaveSuffix("cert_1_BPL_CA_CERTS_PEM.pem")),
+			))
+			Expect(envFile["SSL_CERT_DIR"]).To(Equal(certDir))
+		})
+
+		context("value does not contain PEM data", func() {
+			it.Before(func() {
+				env["BPL_CA_CERTS_PEM"] = "not-pem"
+			})
+
+			it("returns an error", func() {
+				_, err := execd.Execute()
+				Expect(err).To(MatchError(ContainSubstring("failed to load certificates from BPL_CA_CERTS_PEM")))
+			})
+		})
+	})
+
+	context("BPL_CA_CERTS_PATHS is set", func() {
+		var dir string
+
+		it.Before(func() {
+			dir = t.TempDir()
+			raw, err := os.ReadFile(filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.WriteFile(filepath.Join(dir, "ca.pem"), raw, 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, ".hidden"), []byte("not-pem"), 0644)).To(Succeed())
+
+			env["BPL_CA_CERTS_PATHS"] = strings.Join([]string{dir, filepath.Join("testdata", "SecureTrust_CA.pem")}, string(os.PathListSeparator))
+		})
+
+		it("adds the certificates in the files and directories", func() {
+			envFile, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(certPaths).To(ConsistOf(
+				filepath.Join(dir, "ca.pem"),
+				filepath.Join("testdata", "SecureTrust_CA.pem"),
+			))
+			Expect(envFile["SSL_CERT_DIR"]).To(Equal(certDir))
+		})
+
+		it("returns an error for missing paths", func() {
+			env["BPL_CA_CERTS_PATHS"] = filepath.Join(dir, "missing")
+
+			_, err := execd.Execute()
+			Expect(err).To(MatchError(ContainSubstring("failed to load certificates from BPL_CA_CERTS_PATHS")))
+		})
+	})
+
+	context("BPL_CA_CERTS_DISABLED is true", func() {
+		it.Before(func() {
+			execd.Bindings = []libcnb.Binding{
+				{
+					Type:   "ca-certificates",
+					Path:   "testdata",
+					Secret: map[string]string{"SecureTrust_CA.pem": ""},
+				},
+			}
+			env["BPL_CA_CERTS_DISABLED"] = "true"
+		})
+
+		it("does nothing", func() {
+			envFile, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(envFile).To(BeEmpty())
+			Expect(called).To(Equal(0))
+		})
+
+		it("returns an error for invalid values", func() {
+			env["BPL_CA_CERTS_DISABLED"] = "maybe"
+
+			_, err := execd.Execute()
+			Expect(err).To(MatchError(ContainSubstring("invalid value 'maybe' for key 'BPL_CA_CERTS_DISABLED'")))
+		})
+	})
+
+	context("BPL_CA_CERTS_POLICY is set", func() {
+		it.Before(func() {
+			execd.Bindings = []libcnb.Binding{
+				{
+					Name:   "some-binding",
+					Type:   "ca-certificates",
+					Path:   filepath.Join("..", "truststore", "testdata", "policy"),
+					Secret: map[string]string{"weak-rsa-sha1.pem": ""},
+				},
+			}
+		})
+
+		it("rejects weak certificates with the strict policy", func() {
+			env["BPL_CA_CERTS_POLICY"] = "strict"
+
+			_, err := execd.Execute()
+			Expect(err).To(MatchError(ContainSubstring(`CA certificate "CN=Weak RSA SHA1 CA" from key "weak-rsa-sha1.pem" of binding "some-binding" violates the "strict" policy`)))
+			Expect(called).To(Equal(0))
+		})
+
+		it("returns an error for unknown policies", func() {
+			env["BPL_CA_CERTS_POLICY"] = "lax"
+
+			_, err := execd.Execute()
+			Expect(err).To(MatchError(ContainSubstring("invalid value for key 'BPL_CA_CERTS_POLICY'")))
+		})
+	})
+
+	context("BPL_CA_CERTS_LOG_FORMAT is json", func() {
+		var buf *bytes.Buffer
+
+		events := func() []map[string]interface{} {
+			var events []map[string]interface{}
+			dec := json.NewDecoder(buf)
+			for dec.More() {
+				var e map[string]interface{}
+				Expect(dec.Decode(&e)).To(Succeed())
+				events = append(events, e)
+			}
+			return events
+		}
+
+		it.Before(func() {
+			buf = &bytes.Buffer{}
+			execd.Logger = bard.NewLogger(buf)
+			env["BPL_CA_CERTS_LOG_FORMAT"] = "json"
+			execd.Bindings = []libcnb.Binding{
+				{
+					Name:   "some-binding",
+					Type:   "ca-certificates",
+					Path:   "testdata",
+					Secret: map[string]string{"SecureTrust_CA.pem": "", "SecureTrust_CA_Duplicate.pem": ""},
+				},
+				{
+					Name:   "build-binding",
+					Type:   "ca-certificates",
+					Path:   "testdata",
+					Secret: map[string]string{"Go_Daddy_Class_2_CA.pem": "", "scope": "build"},
+				},
+			}
+		})
+
+		it("logs an event for each certificate and a summary", func() {
+			_, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(events()).To(Equal([]map[string]interface{}{
+				{
+					"event":   "skipped",
+					"binding": "build-binding",
+					"key":     "Go_Daddy_Class_2_CA.pem",
+					"path":    filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"),
+					"reason":  `binding has scope "build"`,
+				},
+				{
+					"event":       "skipped",
+					"fingerprint": "f1c1b50ae5a20dd8030ec9f6bc24823dd367b5255759b4e71b61fce9f7375d73",
+					"subject":     "CN=SecureTrust CA,O=SecureTrust Corporation,C=US",
+					"notAfter":    "2029-12-31T19:40:55Z",
+					"binding":     "some-binding",
+					"key":         "SecureTrust_CA_Duplicate.pem",
+					"path":        filepath.Join("testdata", "SecureTrust_CA_Duplicate.pem"),
+					"reason":      `duplicate of certificate from key "SecureTrust_CA.pem" of binding "some-binding"`,
+				},
+				{
+					"event":       "added",
+					"fingerprint": "f1c1b50ae5a20dd8030ec9f6bc24823dd367b5255759b4e71b61fce9f7375d73",
+					"subject":     "CN=SecureTrust CA,O=SecureTrust Corporation,C=US",
+					"notAfter":    "2029-12-31T19:40:55Z",
+					"binding":     "some-binding",
+					"key":         "SecureTrust_CA.pem",
+					"path":        filepath.Join("testdata", "SecureTrust_CA.pem"),
+					"warnings":    []interface{}{"signature algorithm SHA1-RSA is weak (weak-signature)"},
+				},
+				{"event": "summary", "added": 1.0, "skipped": 2.0, "rejected": 0.0, "expiring": 0.0},
+			}))
+		})
+
+		it("logs rejected certificates and the error", func() {
+			env["BPL_CA_CERTS_POLICY"] = "strict"
+			execd.Bindings = []libcnb.Binding{
+				{
+					Name:   "some-binding",
+					Type:   "ca-certificates",
+					Path:   filepath.Join("..", "truststore", "testdata", "policy"),
+					Secret: map[string]string{"weak-rsa-sha1.pem": ""},
+				},
+			}
+
+			_, err := execd.Execute()
+			Expect(err).To(HaveOccurred())
+
+			events := events()
+			Expect(events).To(HaveLen(2))
+			Expect(events[0]).To(HaveKeyWithValue("event", "rejected"))
+			Expect(events[0]).To(HaveKeyWithValue("binding", "some-binding"))
+			Expect(events[0]).To(HaveKeyWithValue("reason", ContainSubstring(`violates the "strict" policy`)))
+			Expect(events[1]).To(HaveKeyWithValue("event", "summary"))
+			Expect(events[1]).To(HaveKeyWithValue("rejected", 1.0))
+			Expect(events[1]).To(HaveKeyWithValue("error", ContainSubstring("failed to load certificates from bindings")))
+		})
+
+		it("returns an error for unknown formats", func() {
+			env["BPL_CA_CERTS_LOG_FORMAT"] = "yaml"
+
+			_, err := execd.Execute()
+			Expect(err).To(MatchError("invalid value 'yaml' for key 'BPL_CA_CERTS_LOG_FORMAT': expected one of [text, json]"))
+		})
+	})
+
+	context("BPL_CA_CERTS_SIGNING_KEYS is set", func() {
+		it.Before(func() {
+			execd.Bindings = []libcnb.Binding{
+				{
+					Name:   "some-binding",
+					Type:   "ca-certificates",
+					Path:   filepath.Join("..", "truststore", "testdata", "signed"),
+					Secret: map[string]string{"ca.pem": "", "ca.pem.sig": ""},
+				},
+			}
+		})
+
+		it("adds certificates signed by a trusted key", func() {
+			raw, err := os.ReadFile(filepath.Join("..", "truststore", "testdata", "signed", "signing_key.pub"))
+			Expect(err).NotTo(HaveOccurred())
+			env["BPL_CA_CERTS_SIGNING_KEYS"] = string(raw)
+
+			_, err = execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(certPaths).To(ConsistOf(And(HavePrefix(certDir), HaveSuffix("ca.pem"))))
+		})
+
+		it("refuses certificates signed by another key", func() {
+			raw, err := os.ReadFile(filepath.Join("..", "truststore", "testdata", "signed", "other_key.pub"))
+			Expect(err).NotTo(HaveOccurred())
+			env["BPL_CA_CERTS_SIGNING_KEYS"] = string(raw)
+
+			_, err = execd.Execute()
+			Expect(err).To(MatchError(ContainSubstring("signed by untrusted key")))
+			Expect(called).To(Equal(0))
+		})
+
+		it("refuses unsigned inline certificates", func() {
+			raw, err := os.ReadFile(filepath.Join("..", "truststore", "testdata", "signed", "signing_key.pub"))
+			Expect(err).NotTo(HaveOccurred())
+			env["BPL_CA_CERTS_SIGNING_KEYS"] = string(raw)
+			raw, err = os.ReadFile(filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"))
+			Expect(err).NotTo(HaveOccurred())
+			env["BPL_CA_CERTS_PEM"] = string(raw)
+
+			_, err = execd.Execute()
+			Expect(err).To(MatchError(ContainSubstring("is neither signed by a trusted key nor allowed by its fingerprint")))
+		})
+
+		it("returns an error for invalid keys", func() {
+			env["BPL_CA_CERTS_SIGNING_KEYS"] = "not-a-key"
+
+			_, err := execd.Execute()
+			Expect(err).To(MatchError(ContainSubstring("invalid value for key 'BPL_CA_CERTS_SIGNING_KEYS'")))
+		})
+	})
+
+	context("BPL_CA_CERTS_ALLOWED_FINGERPRINTS is set", func() {
+		it.Before(func() {
+			raw, err := os.ReadFile(filepath.Join("testdata", "multiple-certs.pem"))
+			Expect(err).NotTo(HaveOccurred())
+			env["BPL_CA_CERTS_PEM"] = string(raw)
+		})
+
+		it("refuses certificates that are not allowed", func() {
+			env["BPL_CA_CERTS_ALLOWED_FINGERPRINTS"] = "C3:84:6B:F2:4B:9E:93:CA:64:27:4C:0E:C6:7C:1E:CC:5E:02:4F:FC:AC:D2:D7:40:19:35:0E:81:FE:54:6A:E4"
+
+			_, err := execd.Execute()
+			Expect(err).To(MatchError(ContainSubstring(`CA certificate "CN=SecureTrust CA,O=SecureTrust Corporation,C=US"`)))
+			Expect(called).To(Equal(0))
+		})
+
+		it("links copies of the verified certificates instead of the binding files", func() {
+			delete(env, "BPL_CA_CERTS_PEM")
+			env["BPL_CA_CERTS_ALLOWED_FINGERPRINTS"] = "c3846bf24b9e93ca64274c0ec67c1ecc5e024ffcacd2d74019350e81fe546ae4"
+			execd.Bindings = []libcnb.Binding{
+				{
+					Name:   "some-binding",
+					Type:   "ca-certificates",
+					Path:   "testdata",
+					Secret: map[string]string{"Go_Daddy_Class_2_CA.pem": ""},
+				},
+			}
+
+			_, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(certPaths).To(HaveLen(1))
+			Expect(certPaths[0]).To(HavePrefix(certDir))
+		})
+
+		it("refuses certificates that are not allowed by the pinned fingerprints", func() {
+			env["BPL_CA_CERTS_PINNED_FINGERPRINTS"] = "C3:84:6B:F2:4B:9E:93:CA:64:27:4C:0E:C6:7C:1E:CC:5E:02:4F:FC:AC:D2:D7:40:19:35:0E:81:FE:54:6A:E4"
+
+			_, err := execd.Execute()
+			Expect(err).To(MatchError(ContainSubstring(`CA certificate "CN=SecureTrust CA,O=SecureTrust Corporation,C=US"`)))
+			Expect(called).To(Equal(0))
+		})
+
+		it("does not lift the pinned fingerprints", func() {
+			env["BPL_CA_CERTS_PINNED_FINGERPRINTS"] = "C3:84:6B:F2:4B:9E:93:CA:64:27:4C:0E:C6:7C:1E:CC:5E:02:4F:FC:AC:D2:D7:40:19:35:0E:81:FE:54:6A:E4"
+			env["BPL_CA_CERTS_ALLOWED_FINGERPRINTS"] = "C3:84:6B:F2:4B:9E:93:CA:64:27:4C:0E:C6:7C:1E:CC:5E:02:4F:FC:AC:D2:D7:40:19:35:0E:81:FE:54:6A:E4," +
+				"F1:C1:B5:0A:E5:A2:0D:D8:03:0E:C9:F6:BC:24:82:3D:D3:67:B5:25:57:59:B4:E7:1B:61:FC:E9:F7:37:5D:73"
+
+			_, err := execd.Execute()
+			Expect(err).To(MatchError(ContainSubstring(`CA certificate "CN=SecureTrust CA,O=SecureTrust Corporation,C=US"`)))
+			Expect(called).To(Equal(0))
+		})
+
+		it("returns an error for invalid fingerprints", func() {
+			env["BPL_CA_CERTS_ALLOWED_FINGERPRINTS"] = "abcd"
+
+			_, err := execd.Execute()
+			Expect(err).To(MatchError(ContainSubstring("invalid value for key 'BPL_CA_CERTS_ALLOWED_FINGERPRINTS'")))
+		})
+	})
+
+	context("Binding has scope build", func() {
+		it.Before(func() {
+			execd.Bindings = []libcnb.Binding{
+				{
+					Name:   "proxy",
+					Type:   "ca-certificates",
+					Path:   "testdata",
+					Secret: map[string]string{"SecureTrust_CA.pem": "", "scope": "build"},
+				},
+				{
+					Name:   "runtime",
+					Type:   "ca-certificates",
+					Path:   "testdata",
+					Secret: map[string]string{"Go_Daddy_Class_2_CA.pem": "", "scope": "launch"},
+				},
+			}
+		})
+
+		it("skips the binding", func() {
+			_, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(certPaths).To(ConsistOf(filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem")))
+		})
+	})
+
+	context("BPL_CA_CERTS_EMBEDDED is set", func() {
+		var buf *bytes.Buffer
+
+		fingerprint := func(name string) string {
+			raw, err := os.ReadFile(filepath.Join("testdata", name))
+			Expect(err).NotTo(HaveOccurred())
+			certs, err := truststore.DecodeCertificates(raw)
+			Expect(err).NotTo(HaveOccurred())
+			return truststore.Fingerprint(certs[0])
+		}
+
+		it.Before(func() {
+			buf = &bytes.Buffer{}
+			execd.Logger = bard.NewLogger(buf)
+
+			record := filepath.Join(t.TempDir(), cacerts.EmbeddedCertsFile)
+			Expect(cacerts.EmbeddedCerts{
+				Dir: "embedded-dir",
+				Certificates: []cacerts.EmbeddedCert{
+					{
+						Fingerprint: fingerprint("SecureTrust_CA.pem"),
+						Subject:     "CN=SecureTrust CA,O=SecureTrust Corporation,C=US",
+						Binding:     "corp-ca",
+						Path:        filepath.Join("testdata", "SecureTrust_CA.pem"),
+					},
+					{
+						Fingerprint: fingerprint("Go_Daddy_Class_2_CA.pem"),
+						Subject:     "OU=Go Daddy Class 2 Certification Authority,O=The Go Daddy Group\\, Inc.,C=US",
+						Binding:     "other-ca",
+						Path:        filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"),
+					},
+				},
+			}.Write(record)).To(Succeed())
+			env["BPL_CA_CERTS_EMBEDDED"] = record
+			env["SSL_CERT_DIR"] = strings.Join([]string{"some-dir", "embedded-dir"}, string(filepath.ListSeparator))
+		})
+
+		it("skips certificates that are already embedded", func() {
+			execd.Bindings = []libcnb.Binding{
+				{
+					Name:   "runtime-ca",
+					Type:   "ca-certificates",
+					Path:   "testdata",
+					Secret: map[string]string{"SecureTrust_CA_Duplicate.pem": "", "USERTrust_ECC_CA_extra_whitespace.pem": ""},
+				},
+			}
+
+			envFile, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(certPaths).To(ConsistOf(filepath.Join("testdata", "USERTrust_ECC_CA_extra_whitespace.pem")))
+			Expect(envFile["SSL_CERT_DIR"]).To(Equal(strings.Join([]string{"some-dir", "embedded-dir", certDir}, string(filepath.ListSeparator))))
+			Expect(buf.String()).To(ContainSubstring(`Skipping CA certificate "CN=SecureTrust CA,O=SecureTrust Corporation,C=US", already embedded`))
+		})
+
+		it("does nothing if all certificates are already embedded", func() {
+			execd.Bindings = []libcnb.Binding{
+				{
+					Name:   "corp-ca",
+					Type:   "ca-certificates",
+					Path:   "testdata",
+					Secret: map[string]string{"SecureTrust_CA.pem": ""},
+				},
+			}
+
+			envFile, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(called).To(BeZero())
+			Expect(envFile).To(BeEmpty())
+		})
+
+		it("lets a runtime binding with the same name override the embedded certificates", func() {
+			execd.Bindings = []libcnb.Binding{
+				{
+					Name:   "corp-ca",
+					Type:   "ca-certificates",
+					Path:   "testdata",
+					Secret: map[string]string{"USERTrust_ECC_CA_extra_whitespace.pem": ""},
+				},
+			}
+
+			envFile, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(certPaths).To(ConsistOf(
+				filepath.Join("testdata", "USERTrust_ECC_CA_extra_whitespace.pem"),
+				filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"),
+			))
+			Expect(envFile["SSL_CERT_DIR"]).To(Equal(strings.Join([]string{"some-dir", certDir}, string(filepath.ListSeparator))))
+			Expect(buf.String()).To(ContainSubstring(`Runtime binding "corp-ca" overrides its 1 embedded CA certificate(s)`))
+		})
+
+		it("does not check the integrity of the remaining embedded certificates", func() {
+			execd.Bindings = []libcnb.Binding{
+				{
+					Name:   "corp-ca",
+					Type:   "ca-certificates",
+					Path:   "testdata",
+					Secret: map[string]string{"USERTrust_ECC_CA_extra_whitespace.pem": ""},
+				},
+			}
+			env["BPL_CA_CERTS_ALLOWED_FINGERPRINTS"] = fingerprint("USERTrust_ECC_CA_extra_whitespace.pem")
+
+			_, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(certPaths).To(ConsistOf(
+				HaveSuffix("USERTrust_ECC_CA_extra_whitespace.pem"),
+				HaveSuffix("Go_Daddy_Class_2_CA.pem"),
+			))
+		})
+
+		context("certificates are embedded for a process type", func() {
+			it.Before(func() {
+				record := filepath.Join(t.TempDir(), cacerts.EmbeddedCertsFile)
+				Expect(cacerts.EmbeddedCerts{
+					Dir:         "embedded-dir",
+					ProcessDirs: map[string]string{"web": "web-dir"},
+					Certificates: []cacerts.EmbeddedCert{
+						{
+							Fingerprint: fingerprint("SecureTrust_CA.pem"),
+							Subject:     "CN=SecureTrust CA,O=SecureTrust Corporation,C=US",
+							Binding:     "web-ca",
+							Path:        filepath.Join("testdata", "SecureTrust_CA.pem"),
+							ProcessType: "web",
+						},
+						{
+							Fingerprint: fingerprint("Go_Daddy_Class_2_CA.pem"),
+							Subject:     "OU=Go Daddy Class 2 Certification Authority,O=The Go Daddy Group\\, Inc.,C=US",
+							Binding:     "other-ca",
+							Path:        filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"),
+						},
+					},
+				}.Write(record)).To(Succeed())
+				env["BPL_CA_CERTS_EMBEDDED"] = record
+				env["SSL_CERT_DIR"] = strings.Join([]string{"some-dir", "embedded-dir", "web-dir"}, string(filepath.ListSeparator))
+			})
+
+			it("skips certificates that are already embedded for the process type", func() {
+				env["CNB_PROCESS_TYPE"] = "web"
+				execd.Bindings = []libcnb.Binding{
+					{
+						Name:   "web-ca",
+						Type:   "ca-certificates",
+						Path:   "testdata",
+						Secret: map[string]string{"SecureTrust_CA.pem": ""},
+					},
+				}
+
+				envFile, err := execd.Execute()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(envFile).To(BeEmpty())
+			})
+
+			it("adds certificates embedded for other process types", func() {
+				env["CNB_PROCESS_TYPE"] = "worker"
+				execd.Bindings = []libcnb.Binding{
+					{
+						Name:   "web-ca",
+						Type:   "ca-certificates",
+						Path:   "testdata",
+						Secret: map[string]string{"SecureTrust_CA.pem": ""},
+					},
+				}
+
+				_, err := execd.Execute()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(certPaths).To(ConsistOf(filepath.Join("testdata", "SecureTrust_CA.pem")))
+			})
+
+			it("lets a runtime binding override the certificates embedded for the process type", func() {
+				env["CNB_PROCESS_TYPE"] = "web"
+				execd.Bindings = []libcnb.Binding{
+					{
+						Name:   "web-ca",
+						Type:   "ca-certificates",
+						Path:   "testdata",
+						Secret: map[string]string{"USERTrust_ECC_CA_extra_whitespace.pem": ""},
+					},
+				}
+
+				envFile, err := execd.Execute()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(certPaths).To(ConsistOf(
+					filepath.Join("testdata", "USERTrust_ECC_CA_extra_whitespace.pem"),
+					filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"),
+				))
+				Expect(envFile["SSL_CERT_DIR"]).To(Equal(strings.Join([]string{"some-dir", certDir}, string(filepath.ListSeparator))))
+				Expect(buf.String()).To(ContainSubstring(`Runtime binding "web-ca" overrides its 1 embedded CA certificate(s)`))
+			})
+		})
+	})
+
+	context("BPL_CA_CERTS_PROCESS_TYPES is set", func() {
+		it.Before(func() {
+			execd.Bindings = []libcnb.Binding{
+				{
+					Name:   "web-ca",
+					Type:   "ca-certificates",
+					Path:   "testdata",
+					Secret: map[string]string{"SecureTrust_CA.pem": ""},
+				},
+				{
+					Name:   "shared-ca",
+					Type:   "ca-certificates",
+					Path:   "testdata",
+					Secret: map[string]string{"Go_Daddy_Class_2_CA.pem": ""},
+				},
+			}
+			env["BPL_CA_CERTS_PROCESS_TYPES"] = "web-ca=web"
+		})
+
+		it("adds mapped certificates for the process type", func() {
+			env["BPL_CA_CERTS_PROCESS_TYPE"] = "web"
+
+			_, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(certPaths).To(ConsistOf(
+				filepath.Join("testdata", "SecureTrust_CA.pem"),
+				filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"),
+			))
+		})
+
+		it("skips mapped certificates for other process types", func() {
+			env["CNB_PROCESS_TYPE"] = "worker"
+
+			_, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(certPaths).To(ConsistOf(filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem")))
+		})
+
+		it("returns an error for invalid mappings", func() {
+			env["BPL_CA_CERTS_PROCESS_TYPES"] = "web-ca="
+
+			_, err := execd.Execute()
+			Expect(err).To(MatchError(ContainSubstring("invalid value for key 'BPL_CA_CERTS_PROCESS_TYPES'")))
+		})
+	})
+
+	context("Binding is a kubernetes.io/tls secret", func() {
+		it.Before(func() {
+			execd.Bindings = []libcnb.Binding{
+				{
+					Name: "some-tls-secret",
+					Type: "ca-certificates",
+					Path: filepath.Join("..", "truststore", "testdata", "tls-secret"),
+					Secret: map[string]string{
+						"ca.crt":  "",
+						"tls.crt": "",
+						"tls.key": "",
+					},
+				},
+			}
+		})
+
+		it("trusts ca.crt and the CA certificates from tls.crt only", func() {
+			_, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			// The CA certificate in tls.crt is the same as ca.crt
+			Expect(certPaths).To(ConsistOf(
+				filepath.Join("..", "truststore", "testdata", "tls-secret", "ca.crt"),
+			))
+		})
+	})
+
+	context("Binding contains keys that are not certificates", func() {
+		it.Before(func() {
+			execd.Bindings = []libcnb.Binding{
+				{
+					Type: "ca-certificates",
+					Path: "testdata",
+					Secret: map[string]string{
+						"SecureTrust_CA.pem": "",
+						"README.md":          "",
+					},
+				},
+			}
+		})
+
+		it("only loads keys matching the default patterns", func() {
+			_, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(certPaths).To(Equal([]string{filepath.Join("testdata", "SecureTrust_CA.pem")}))
+		})
+
+		context("BPL_CA_CERTS_BINDING_KEYS is set", func() {
+			it.Before(func() {
+				env["BPL_CA_CERTS_BINDING_KEYS"] = "!SecureTrust_*"
+			})
+
+			it("does not load excluded keys", func() {
+				envFile, err := execd.Execute()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(called).To(Equal(0))
+				Expect(envFile).To(BeEmpty())
+			})
+		})
+
+		context("BPL_CA_CERTS_BINDING_KEYS is invalid", func() {
+			it.Before(func() {
+				env["BPL_CA_CERTS_BINDING_KEYS"] = "["
+			})
+
+			it("returns an error", func() {
+				_, err := execd.Execute()
+				Expect(err).To(MatchError(ContainSubstring("invalid value for key 'BPL_CA_CERTS_BINDING_KEYS'")))
+			})
+		})
+	})
+
+	context("Binding is a Kubernetes atomic writer volume", func() {
+		var bindingDir string
+
+		it.Before(func() {
+			bindingDir = t.TempDir()
+			data := filepath.Join(bindingDir, "..2024_01_01_00_00_00.000000000")
+			Expect(os.MkdirAll(filepath.Join(data, "nested"), 0755)).To(Succeed())
+			for _, f := range []string{"SecureTrust_CA.pem", "Go_Daddy_Class_2_CA.pem"} {
+				raw, err := os.ReadFile(filepath.Join("testdata", f))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(filepath.Join(data, f), raw, 0644)).To(Succeed())
+			}
+			Expect(os.Rename(filepath.Join(data, "Go_Daddy_Class_2_CA.pem"), filepath.Join(data, "nested", "ca.pem"))).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(data, "type"), []byte("ca-certificates"), 0644)).To(Succeed())
+
+			Expect(os.Symlink(filepath.Base(data), filepath.Join(bindingDir, "..data"))).To(Succeed())
+			Expect(os.Symlink(filepath.Join("..data", "type"), filepath.Join(bindingDir, "type"))).To(Succeed())
+			Expect(os.Symlink(filepath.Join("..data", "nested"), filepath.Join(bindingDir, "nested"))).To(Succeed())
+			Expect(os.Symlink(filepath.Join("..data", "SecureTrust_CA.pem"), filepath.Join(bindingDir, "ca.pem"))).To(Succeed())
+			Expect(os.Symlink(filepath.Join("..data", "SecureTrust_CA.pem"), filepath.Join(bindingDir, "same-ca.pem"))).To(Succeed())
+
+			binding, err := libcnb.NewBindingFromPath(bindingDir)
+			Expect(err).NotTo(HaveOccurred())
+			binding.Secret[".hidden.pem"] = ""
+			execd.Bindings = libcnb.Bindings{binding}
+		})
+
+		it("skips hidden keys, includes nested keys and links each file once via its stable path", func() {
+			_, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(certPaths).To(Equal([]string{
+				filepath.Join(bindingDir, "ca.pem"),
+				filepath.Join(bindingDir, "nested", "ca.pem"),
+			}))
+		})
+	})
+
+	context("BPL_CA_CERTS_WATCH is set to true", func() {
+		var watchedDir string
+
+		it.Before(func() {
+			env["BPL_CA_CERTS_WATCH"] = "true"
+			execd.StartWatcher = func(dir string) error {
+				watchedDir = dir
+				return nil
+			}
+		})
+
+		it("starts the watcher even if there are no bindings yet", func() {
+			envFile, err := execd.Execute()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(watchedDir).To(Equal(certDir))
+			Expect(envFile["SSL_CERT_DIR"]).To(Equal(certDir))
+		})
+
+		context("BPL_CA_CERTS_WATCH is invalid", func() {
+			it.Before(func() {
+				env["BPL_CA_CERTS_WATCH"] = "sometimes"
+			})
+
+			it("returns an error", func() {
+				_, err := execd.Execute()
+				Expect(err).To(MatchError(ContainSubstring("invalid value 'sometimes' for key 'BPL_CA_CERTS_WATCH'")))
+			})
+		})
+	})
 }
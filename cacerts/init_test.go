@@ -28,7 +28,12 @@ func TestUnit(t *testing.T) {
 	suite("Detect", testDetect)
 	suite("Build", testBuild)
 	suite("ExecD", testExecD)
+	suite("Inspector", testInspector)
+	suite("Verifier", testVerifier)
 	suite("Certs", testCerts)
+	suite("ProcessTypes", testProcessTypes)
+	suite("Report", testReport)
 	suite("TrustedCACerts", testTrustedCACerts)
+	suite("Watcher", testWatcher)
 	suite.Run(t)
 }
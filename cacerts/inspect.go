@@ -0,0 +1,253 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+const (
+	// CommandInspect is the helper command that lists the certificates in the effective truststore.
+	CommandInspect = "inspect"
+
+	// SourceInline is the source of certificates provided via $BPL_CA_CERTS_PEM.
+	SourceInline = "BPL_CA_CERTS_PEM"
+
+	// SourcePaths is the source of certificates in the files and directories listed in $BPL_CA_CERTS_PATHS.
+	SourcePaths = "BPL_CA_CERTS_PATHS"
+)
+
+// TrustedCertificate describes a certificate in the effective truststore.
+type TrustedCertificate struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Fingerprint string    `json:"fingerprint"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+
+	// HashLink is the path of the hash link in SSL_CERT_DIR, empty for certificates from SSL_CERT_FILE.
+	HashLink string `json:"hashLink,omitempty"`
+
+	// File is the path of the file containing the certificate.
+	File string `json:"file"`
+
+	// Source is the name of the ca-certificates binding, SourcePaths or SourceInline if the certificate was added by
+	// the helper. For certificates added at build time it is the name of the binding or the source of the plan entry.
+	Source string `json:"source,omitempty"`
+
+	// Embedded is true if the certificate was embedded into the application image at build time.
+	Embedded bool `json:"embedded,omitempty"`
+
+	// Rejected is the reason the helper rejects the certificate with the current policy and integrity configuration,
+	// empty if the certificate is accepted or was not added by the helper.
+	Rejected string `json:"rejected,omitempty"`
+
+	// Dangling is true if HashLink points to File, which does not exist. Only HashLink and File are set.
+	Dangling bool `json:"dangling,omitempty"`
+
+	// Certificate is the parsed certificate.
+	Certificate *x509.Certificate `json:"-"`
+}
+
+// Inspector lists the certificates reachable through SSL_CERT_FILE and SSL_CERT_DIR.
+type Inspector struct {
+	// ExecD provides the environment and the bindings used to determine the source of certificates.
+	ExecD *ExecD
+}
+
+// Certificates returns the certificates in SSL_CERT_FILE followed by the certificates linked from each directory in
+// SSL_CERT_DIR. Unset variables default to DefaultCAFile and DefaultCAPath respectively, missing files and
+// directories are ignored. Hash links whose target does not exist are returned as Dangling.
+func (i Inspector) Certificates() ([]TrustedCertificate, error) {
+	sources, err := i.sources()
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []TrustedCertificate
+
+	file := i.ExecD.GetEnv(EnvCAFile)
+	if file == "" {
+		file = DefaultCAFile
+	}
+	c, err := i.certificates(file, "", sources)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	certs = append(certs, c...)
+
+	dirs := i.ExecD.GetEnv(EnvCAPath)
+	if dirs == "" {
+		dirs = DefaultCAPath
+	}
+	for _, dir := range filepath.SplitList(dirs) {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read directory %q\n%w", dir, err)
+		}
+		for _, entry := range entries {
+			if !truststore.IsHashLink(entry.Name()) {
+				continue
+			}
+			link := filepath.Join(dir, entry.Name())
+			target, err := filepath.EvalSymlinks(link)
+			if os.IsNotExist(err) {
+				target, err = os.Readlink(link)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read link %q\n%w", link, err)
+				}
+				certs = append(certs, TrustedCertificate{HashLink: link, File: target, Dangling: true})
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to resolve %q\n%w", link, err)
+			}
+			c, err := i.certificates(target, link, sources)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, c...)
+		}
+	}
+
+	return certs, nil
+}
+
+// Write writes the certificates to w in the given format, either "text" or "json".
+func (i Inspector) Write(w io.Writer, format string) error {
+	certs, err := i.Certificates()
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(certs)
+	case "text":
+		for _, c := range certs {
+			location := c.File
+			if c.HashLink != "" {
+				location = fmt.Sprintf("%s -> %s", c.HashLink, c.File)
+			}
+			if c.Dangling {
+				if _, err := fmt.Fprintf(w, "%s\n  Dangling:    target does not exist\n\n", location); err != nil {
+					return err
+				}
+				continue
+			}
+			expiry := c.NotAfter.UTC().Format(time.RFC3339)
+			if time.Now().After(c.NotAfter) {
+				expiry += " (expired)"
+			}
+
+			var b strings.Builder
+			fmt.Fprintf(&b, "%s\n", location)
+			fmt.Fprintf(&b, "  Subject:     %s\n", c.Subject)
+			fmt.Fprintf(&b, "  Issuer:      %s\n", c.Issuer)
+			fmt.Fprintf(&b, "  Fingerprint: %s\n", c.Fingerprint)
+			fmt.Fprintf(&b, "  Not After:   %s\n", expiry)
+			if c.Source != "" {
+				fmt.Fprintf(&b, "  Source:      %s\n", c.Source)
+			}
+			if c.Embedded {
+				fmt.Fprintf(&b, "  Embedded:    true\n")
+			}
+			if c.Rejected != "" {
+				fmt.Fprintf(&b, "  Rejected:    %s\n", c.Rejected)
+			}
+			if _, err := fmt.Fprintln(w, b.String()); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported format %q, expected one of [text, json]", format)
+	}
+}
+
+func (i Inspector) certificates(path string, link string, sources map[string]TrustedCertificate) ([]TrustedCertificate, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := truststore.DecodeCertificates(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode certificates from file at path %q\n%w", path, err)
+	}
+
+	var result []TrustedCertificate
+	for _, cert := range certs {
+		fingerprint := truststore.Fingerprint(cert)
+		result = append(result, TrustedCertificate{
+			Subject:     cert.Subject.String(),
+			Issuer:      cert.Issuer.String(),
+			Fingerprint: fingerprint,
+			NotBefore:   cert.NotBefore,
+			NotAfter:    cert.NotAfter,
+			HashLink:    link,
+			File:        path,
+			Source:      sources[fingerprint].Source,
+			Embedded:    sources[fingerprint].Embedded,
+			Rejected:    sources[fingerprint].Rejected,
+			Certificate: cert,
+		})
+	}
+	return result, nil
+}
+
+// sources returns the source of each certificate keyed by fingerprint. Certificates embedded at build time are
+// described by the Report in $BPL_CA_CERTS_REPORT, the sources of certificates added by the helper take precedence.
+// The certificates of the helper are loaded without enforcing the policy and the integrity configuration, so that
+// certificates the helper would reject are listed with the reason instead of failing the inspection.
+func (i Inspector) sources() (map[string]TrustedCertificate, error) {
+	sources := map[string]TrustedCertificate{}
+
+	if path := i.ExecD.GetEnv(EnvReport); path != "" {
+		report, err := ReadReport(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		for _, c := range report.Certificates {
+			sources[c.Fingerprint] = c
+		}
+	}
+
+	ts, err := i.ExecD.truststore(true)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range ts.Certificates() {
+		s := sources[c.Fingerprint]
+		s.Source = c.Origins[0].Name
+		s.Rejected = c.Rejected
+		sources[c.Fingerprint] = s
+	}
+	return sources, nil
+}
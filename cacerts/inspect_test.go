@@ -0,0 +1,173 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildpacks/libcnb"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/cacerts"
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+func testInspector(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		certDir   string
+		env       map[string]string
+		inspector cacerts.Inspector
+	)
+
+	it.Before(func() {
+		certDir = t.TempDir()
+		goDaddy, err := filepath.Abs(filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(truststore.SyncHashLinks(certDir, []string{goDaddy})).To(Succeed())
+		abs, err := filepath.Abs(filepath.Join("testdata", "SecureTrust_CA.pem"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Symlink(abs, filepath.Join(certDir, "f39fc864.0"))).To(Succeed())
+
+		env = map[string]string{
+			"SSL_CERT_FILE": filepath.Join("testdata", "SecureTrust_CA.pem"),
+			"SSL_CERT_DIR":  certDir + string(os.PathListSeparator) + filepath.Join(certDir, "missing"),
+		}
+		execd := cacerts.NewExecD(libcnb.Bindings{
+			{
+				Name:   "some-binding",
+				Type:   cacerts.BindingType,
+				Path:   "testdata",
+				Secret: map[string]string{"Go_Daddy_Class_2_CA.pem": ""},
+			},
+		})
+		execd.GetEnv = func(k string) string { return env[k] }
+		inspector = cacerts.Inspector{ExecD: execd}
+	})
+
+	it("lists the certificates in SSL_CERT_FILE and SSL_CERT_DIR", func() {
+		certs, err := inspector.Certificates()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(certs).To(HaveLen(3))
+
+		Expect(certs[0].File).To(Equal(filepath.Join("testdata", "SecureTrust_CA.pem")))
+		Expect(certs[0].HashLink).To(BeEmpty())
+		Expect(certs[0].Subject).To(Equal("CN=SecureTrust CA,O=SecureTrust Corporation,C=US"))
+		Expect(certs[0].Source).To(BeEmpty())
+
+		Expect(certs[1].HashLink).To(Equal(filepath.Join(certDir, "f081611a.0")))
+		Expect(certs[1].File).To(HaveSuffix(filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem")))
+		Expect(certs[1].Fingerprint).To(Equal("c3846bf24b9e93ca64274c0ec67c1ecc5e024ffcacd2d74019350e81fe546ae4"))
+		Expect(certs[1].Source).To(Equal("some-binding"))
+
+		Expect(certs[2].HashLink).To(Equal(filepath.Join(certDir, "f39fc864.0")))
+	})
+
+	it("attributes certificates from BPL_CA_CERTS_PEM", func() {
+		raw, err := os.ReadFile(filepath.Join("testdata", "SecureTrust_CA.pem"))
+		Expect(err).NotTo(HaveOccurred())
+		env["BPL_CA_CERTS_PEM"] = string(raw)
+
+		certs, err := inspector.Certificates()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(certs[2].Source).To(Equal(cacerts.SourceInline))
+	})
+
+	it("attributes certificates from the report of the embedded certificates", func() {
+		report := filepath.Join(t.TempDir(), "report.json")
+		Expect(cacerts.Report{Certificates: []cacerts.TrustedCertificate{
+			{Fingerprint: "f1c1b50ae5a20dd8030ec9f6bc24823dd367b5255759b4e71b61fce9f7375d73", Source: "corp-ca", Embedded: true},
+		}}.Write(report)).To(Succeed())
+		env["BPL_CA_CERTS_REPORT"] = report
+
+		certs, err := inspector.Certificates()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(certs[2].Source).To(Equal("corp-ca"))
+		Expect(certs[2].Embedded).To(BeTrue())
+		Expect(certs[1].Source).To(Equal("some-binding"))
+		Expect(certs[1].Embedded).To(BeFalse())
+	})
+
+	it("marks certificates the helper rejects by their fingerprint", func() {
+		env["BPL_CA_CERTS_ALLOWED_FINGERPRINTS"] = "f1c1b50ae5a20dd8030ec9f6bc24823dd367b5255759b4e71b61fce9f7375d73"
+
+		certs, err := inspector.Certificates()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(certs).To(HaveLen(3))
+		Expect(certs[1].Source).To(Equal("some-binding"))
+		Expect(certs[1].Rejected).To(Equal("fingerprint is not allowed and file is not signed by a trusted key"))
+	})
+
+	it("marks certificates the helper rejects by its policy", func() {
+		env["BPL_CA_CERTS_POLICY"] = "strict"
+
+		certs, err := inspector.Certificates()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(certs[1].Rejected).To(ContainSubstring(`violates the "strict" policy`))
+
+		buf := &bytes.Buffer{}
+		Expect(inspector.Write(buf, "text")).To(Succeed())
+		Expect(buf.String()).To(ContainSubstring(`  Rejected:    violates the "strict" policy`))
+	})
+
+	it("lists dangling hash links", func() {
+		missing := filepath.Join(t.TempDir(), "missing.pem")
+		Expect(os.Symlink(missing, filepath.Join(certDir, "12345678.0"))).To(Succeed())
+
+		certs, err := inspector.Certificates()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(certs).To(HaveLen(4))
+		Expect(certs[1]).To(Equal(cacerts.TrustedCertificate{
+			HashLink: filepath.Join(certDir, "12345678.0"),
+			File:     missing,
+			Dangling: true,
+		}))
+
+		buf := &bytes.Buffer{}
+		Expect(inspector.Write(buf, "text")).To(Succeed())
+		Expect(buf.String()).To(ContainSubstring(filepath.Join(certDir, "12345678.0") + " -> " + missing + "\n  Dangling:    target does not exist\n"))
+	})
+
+	it("writes text", func() {
+		buf := &bytes.Buffer{}
+		Expect(inspector.Write(buf, "text")).To(Succeed())
+		Expect(buf.String()).To(ContainSubstring(filepath.Join(certDir, "f081611a.0") + " -> "))
+		Expect(buf.String()).To(ContainSubstring("  Fingerprint: c3846bf24b9e93ca64274c0ec67c1ecc5e024ffcacd2d74019350e81fe546ae4\n"))
+		Expect(buf.String()).To(ContainSubstring("  Not After:   2034-06-29T17:06:20Z\n"))
+		Expect(buf.String()).To(ContainSubstring("  Source:      some-binding\n"))
+	})
+
+	it("writes json", func() {
+		buf := &bytes.Buffer{}
+		Expect(inspector.Write(buf, "json")).To(Succeed())
+
+		var certs []cacerts.TrustedCertificate
+		Expect(json.Unmarshal(buf.Bytes(), &certs)).To(Succeed())
+		Expect(certs).To(HaveLen(3))
+		Expect(certs[1].Source).To(Equal("some-binding"))
+	})
+
+	it("rejects unknown formats", func() {
+		Expect(inspector.Write(&bytes.Buffer{}, "yaml")).To(MatchError(`unsupported format "yaml", expected one of [text, json]`))
+	})
+}
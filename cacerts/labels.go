@@ -0,0 +1,83 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/buildpacks/libcnb"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+const (
+	// LabelEmbeddedCount is the image label containing the number of embedded CA certificates.
+	LabelEmbeddedCount = "io.paketo.ca-certificates.embedded.count"
+
+	// LabelEmbeddedFingerprints is the image label containing the sorted, comma separated SHA-256 fingerprints of the
+	// embedded CA certificates.
+	LabelEmbeddedFingerprints = "io.paketo.ca-certificates.embedded.fingerprints"
+
+	// LabelEmbeddedDigest is the image label containing the SHA-256 digest of the sorted fingerprints of the embedded
+	// CA certificates, each followed by a newline.
+	LabelEmbeddedDigest = "io.paketo.ca-certificates.embedded.digest"
+)
+
+// EmbeddedDigest returns the value of LabelEmbeddedDigest for the given fingerprints.
+func EmbeddedDigest(fingerprints []string) string {
+	sorted := append([]string{}, fingerprints...)
+	sort.Strings(sorted)
+
+	h := sha256.New()
+	for _, f := range sorted {
+		fmt.Fprintf(h, "%s\n", f)
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil))
+}
+
+// embeddedLabels returns the image labels describing the certificates of stores that are trusted at launch time,
+// i.e. embedded into the image. No labels are returned if no certificates are embedded.
+func embeddedLabels(trusts []trust, stores map[trust]*truststore.Truststore) []libcnb.Label {
+	embedded := map[string]bool{}
+	for _, t := range trusts {
+		if !t.launch {
+			continue
+		}
+		for _, c := range stores[t].Certificates() {
+			embedded[c.Fingerprint] = true
+		}
+	}
+	if len(embedded) == 0 {
+		return nil
+	}
+
+	var fingerprints []string
+	for f := range embedded {
+		fingerprints = append(fingerprints, f)
+	}
+	sort.Strings(fingerprints)
+
+	return []libcnb.Label{
+		{Key: LabelEmbeddedCount, Value: strconv.Itoa(len(fingerprints))},
+		{Key: LabelEmbeddedFingerprints, Value: strings.Join(fingerprints, ",")},
+		{Key: LabelEmbeddedDigest, Value: EmbeddedDigest(fingerprints)},
+	}
+}
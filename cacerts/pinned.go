@@ -0,0 +1,84 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts
+
+import (
+	"strings"
+
+	"github.com/buildpacks/libcnb"
+	"github.com/paketo-buildpacks/libpak"
+	"github.com/paketo-buildpacks/libpak/bard"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+const (
+	// EnvPinnedFingerprints is set in the launch environment to the fingerprints allowed at build time.
+	EnvPinnedFingerprints = "BPL_CA_CERTS_PINNED_FINGERPRINTS"
+
+	// EnvPinnedSigningKeys is set in the launch environment to the signing keys trusted at build time.
+	EnvPinnedSigningKeys = "BPL_CA_CERTS_PINNED_SIGNING_KEYS"
+)
+
+// PinnedIntegrity is a launch layer that pins the fingerprints and signing keys configured with
+// BP_CA_CERTS_ALLOWED_FINGERPRINTS and BP_CA_CERTS_SIGNING_KEYS into the image. The helper enforces them in addition
+// to BPL_CA_CERTS_ALLOWED_FINGERPRINTS and BPL_CA_CERTS_SIGNING_KEYS, so that the runtime configuration can only add
+// restrictions.
+type PinnedIntegrity struct {
+	Fingerprints     string
+	SigningKeys      string
+	LayerContributor libpak.LayerContributor
+	Logger           bard.Logger
+}
+
+// NewPinnedIntegrity creates a layer pinning the fingerprints and signing keys of integrity.
+func NewPinnedIntegrity(integrity truststore.Integrity) *PinnedIntegrity {
+	var keys []string
+	for _, k := range integrity.SigningKeys {
+		keys = append(keys, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k))))
+	}
+	p := &PinnedIntegrity{
+		Fingerprints: strings.Join(integrity.Fingerprints, ","),
+		SigningKeys:  strings.Join(keys, "\n"),
+	}
+	p.LayerContributor = libpak.NewLayerContributor(
+		"Pinned CA Certificate Integrity",
+		map[string]interface{}{
+			"fingerprints": p.Fingerprints,
+			"signing-keys": p.SigningKeys,
+		},
+		libcnb.LayerTypes{
+			Launch: true,
+		},
+	)
+	return p
+}
+
+func (p PinnedIntegrity) Contribute(layer libcnb.Layer) (libcnb.Layer, error) {
+	p.LayerContributor.Logger = p.Logger
+	return p.LayerContributor.Contribute(layer, func() (libcnb.Layer, error) {
+		layer.LaunchEnvironment.Override(EnvPinnedFingerprints, p.Fingerprints)
+		layer.LaunchEnvironment.Override(EnvPinnedSigningKeys, p.SigningKeys)
+		p.Logger.Bodyf("Pinned the allowed fingerprints and signing keys of CA certificates at launch")
+		return layer, nil
+	})
+}
+
+func (p PinnedIntegrity) Name() string {
+	return "ca-certificates-pins"
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts
+
+import (
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+// PlanEntryMetadataVersion is the version of the ca-certificates build plan entry metadata schema.
+const PlanEntryMetadataVersion = truststore.PlanEntryMetadataVersion
+
+// Scopes in which the certificates of a ca-certificates build plan entry may be trusted, see truststore.ScopeBuild.
+const (
+	ScopeBuild  = truststore.ScopeBuild
+	ScopeLaunch = truststore.ScopeLaunch
+	ScopeBoth   = truststore.ScopeBoth
+)
+
+// Sources of the build plan entries required by this buildpack's Detect.
+const (
+	// SourceApplication indicates certificates found in the application directory.
+	SourceApplication = "application"
+
+	// SourceEnvironment indicates certificates provided via $BP_CA_CERTS_PEM.
+	SourceEnvironment = "environment"
+)
+
+// PlanEntryMetadata is the metadata of a "ca-certificates" build plan entry.
+type PlanEntryMetadata = truststore.PlanEntryMetadata
+
+// PlanEntryMetadataError describes an invalid field of ca-certificates build plan entry metadata.
+type PlanEntryMetadataError = truststore.PlanEntryMetadataError
+
+// NewPlanEntryMetadata decodes and validates the metadata of a ca-certificates build plan entry.
+func NewPlanEntryMetadata(md map[string]interface{}) (PlanEntryMetadata, error) {
+	return truststore.NewPlanEntryMetadata(md)
+}
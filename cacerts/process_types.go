@@ -0,0 +1,87 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// EnvProcessType is the process type the helper adds certificates for. The CA certificates layer sets it in the
+	// launch environment of each process type that certificates are mapped to.
+	EnvProcessType = "BPL_CA_CERTS_PROCESS_TYPE"
+
+	// EnvCNBProcessType is the process type the helper adds certificates for if EnvProcessType is not set.
+	EnvCNBProcessType = "CNB_PROCESS_TYPE"
+
+	// ProcessCertsDir is the directory, relative to the layer, containing a hash directory for each process type.
+	ProcessCertsDir = "process-certs"
+)
+
+// ProcessTypes maps the names of bindings and the sources of build plan entries to the process types that trust their
+// certificates. Certificates from sources that are not mapped are trusted by all process types.
+type ProcessTypes map[string][]string
+
+// ParseProcessTypes parses a semicolon or whitespace separated list of mappings of the form
+// "<source>=<process-type>[,<process-type>...]", e.g. "corp-ca=web,worker; proxy=migrations".
+func ParseProcessTypes(s string) (ProcessTypes, error) {
+	p := ProcessTypes{}
+	for _, m := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || unicode.IsSpace(r) }) {
+		source, types, ok := strings.Cut(m, "=")
+		if !ok || source == "" {
+			return nil, fmt.Errorf("invalid process type mapping %q, expected <source>=<process-type>[,<process-type>...]", m)
+		}
+		for _, t := range strings.Split(types, ",") {
+			if t == "" {
+				return nil, fmt.Errorf("invalid process type mapping %q, process type must not be empty", m)
+			}
+			if !slices.Contains(p[source], t) {
+				p[source] = append(p[source], t)
+			}
+		}
+	}
+	return p, nil
+}
+
+// Trusts returns true if processType trusts certificates from source.
+func (p ProcessTypes) Trusts(source string, processType string) bool {
+	types, ok := p[source]
+	return !ok || slices.Contains(types, processType)
+}
+
+// For returns the sorted process types that trust certificates from any of sources, or nil if all process types
+// trust them because one of the sources is not mapped.
+func (p ProcessTypes) For(sources ...string) []string {
+	var types []string
+	for _, s := range sources {
+		t, ok := p[s]
+		if !ok {
+			return nil
+		}
+		for _, name := range t {
+			if !slices.Contains(types, name) {
+				types = append(types, name)
+			}
+		}
+	}
+	sort.Strings(types)
+	return types
+}
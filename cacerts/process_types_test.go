@@ -0,0 +1,64 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/cacerts"
+)
+
+func testProcessTypes(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+	)
+
+	it("parses mappings", func() {
+		p, err := cacerts.ParseProcessTypes("corp-ca=web,worker; proxy=migrations\nproxy=web")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p).To(Equal(cacerts.ProcessTypes{
+			"corp-ca": {"web", "worker"},
+			"proxy":   {"migrations", "web"},
+		}))
+	})
+
+	it("returns an error for invalid mappings", func() {
+		_, err := cacerts.ParseProcessTypes("corp-ca")
+		Expect(err).To(MatchError(`invalid process type mapping "corp-ca", expected <source>=<process-type>[,<process-type>...]`))
+
+		_, err = cacerts.ParseProcessTypes("corp-ca=web,")
+		Expect(err).To(MatchError(`invalid process type mapping "corp-ca=web,", process type must not be empty`))
+	})
+
+	it("trusts unmapped sources for all process types", func() {
+		p := cacerts.ProcessTypes{"corp-ca": {"web"}}
+
+		Expect(p.Trusts("corp-ca", "web")).To(BeTrue())
+		Expect(p.Trusts("corp-ca", "worker")).To(BeFalse())
+		Expect(p.Trusts("other", "worker")).To(BeTrue())
+	})
+
+	it("returns the process types of sources", func() {
+		p := cacerts.ProcessTypes{"corp-ca": {"worker", "web"}, "proxy": {"migrations"}}
+
+		Expect(p.For("corp-ca", "proxy")).To(Equal([]string{"migrations", "web", "worker"}))
+		Expect(p.For("corp-ca", "other")).To(BeNil())
+	})
+}
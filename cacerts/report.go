@@ -0,0 +1,175 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/buildpacks/libcnb"
+	"github.com/paketo-buildpacks/libpak/bard"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+const (
+	// ReportFile is the file, relative to the layer, listing the certificates trusted by the layer.
+	ReportFile = "report.json"
+
+	// EnvReport is set in the launch environment to the path of the ReportFile of the embedded certificates.
+	EnvReport = "BPL_CA_CERTS_REPORT"
+)
+
+// Report lists the certificates trusted by a CA certificates layer.
+type Report struct {
+	Certificates []TrustedCertificate `json:"certificates"`
+}
+
+// ReadReport reads the Report at path.
+func ReadReport(path string) (Report, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to read %q\n%w", path, err)
+	}
+	var r Report
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return Report{}, fmt.Errorf("failed to decode %q\n%w", path, err)
+	}
+	return r, nil
+}
+
+// Write writes the report to path.
+func (r Report) Write(path string) error {
+	raw, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report\n%w", err)
+	}
+	if err := os.WriteFile(path, raw, truststore.FileMode); err != nil {
+		return fmt.Errorf("failed to write %q\n%w", path, err)
+	}
+	return nil
+}
+
+// Log logs the certificates as a table.
+func (r Report) Log(logger bard.Logger) {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SUBJECT\tISSUER\tFINGERPRINT\tNOT AFTER\tSOURCE\tEMBEDDED")
+	for _, c := range r.Certificates {
+		source := c.Source
+		if source == "" {
+			source = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\n",
+			c.Subject, c.Issuer, c.Fingerprint, c.NotAfter.UTC().Format(time.DateOnly), source, c.Embedded)
+	}
+	w.Flush()
+
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+		logger.Body(line)
+	}
+}
+
+// contributeReport writes the ReportFile of the certificates in the layer and logs it.
+func (l TrustedCACerts) contributeReport(layer libcnb.Layer) error {
+	report := Report{Certificates: []TrustedCertificate{}}
+
+	add := func(dir string, paths []string) error {
+		links, err := linksByTarget(dir)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read file at path %q\n%w", path, err)
+			}
+			certs, err := truststore.DecodeCertificates(raw)
+			if err != nil {
+				return fmt.Errorf("failed to decode certificates from file at path %q\n%w", path, err)
+			}
+			for _, c := range certs {
+				report.Certificates = append(report.Certificates, TrustedCertificate{
+					Subject:     c.Subject.String(),
+					Issuer:      c.Issuer.String(),
+					Fingerprint: truststore.Fingerprint(c),
+					NotBefore:   c.NotBefore,
+					NotAfter:    c.NotAfter,
+					HashLink:    links[path],
+					File:        path,
+					Source:      l.CertSources[path],
+					Embedded:    l.EmbeddedCerts,
+				})
+			}
+		}
+		return nil
+	}
+
+	if err := add(filepath.Join(layer.Path, CACertsDir), l.CertPaths); err != nil {
+		return err
+	}
+	var processTypes []string
+	for processType := range l.ProcessCertPaths {
+		processTypes = append(processTypes, processType)
+	}
+	sort.Strings(processTypes)
+	for _, processType := range processTypes {
+		if err := add(filepath.Join(layer.Path, ProcessCertsDir, processType), l.ProcessCertPaths[processType]); err != nil {
+			return err
+		}
+	}
+
+	file := filepath.Join(layer.Path, ReportFile)
+	if err := report.Write(file); err != nil {
+		return err
+	}
+	if l.EmbeddedCerts {
+		layer.LaunchEnvironment.Override(EnvReport, file)
+	}
+	if len(report.Certificates) > 0 {
+		report.Log(l.Logger)
+	}
+	return nil
+}
+
+// linksByTarget returns the paths of the hash links in dir keyed by the path of the certificate they link to.
+func linksByTarget(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %q\n%w", dir, err)
+	}
+	links := map[string]string{}
+	for _, entry := range entries {
+		if !truststore.IsHashLink(entry.Name()) {
+			continue
+		}
+		link := filepath.Join(dir, entry.Name())
+		target, err := os.Readlink(link)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read link %q\n%w", link, err)
+		}
+		links[target] = link
+	}
+	return links, nil
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buildpacks/libcnb"
+	. "github.com/onsi/gomega"
+	"github.com/paketo-buildpacks/libpak/bard"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/cacerts"
+)
+
+func testReport(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		buf        *bytes.Buffer
+		layer      libcnb.Layer
+		trustedCAs *cacerts.TrustedCACerts
+		goDaddy    string
+	)
+
+	it.Before(func() {
+		var err error
+		layers := &libcnb.Layers{Path: t.TempDir()}
+		layer, err = layers.Layer("test-layer")
+		Expect(err).NotTo(HaveOccurred())
+
+		goDaddy, err = filepath.Abs(filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"))
+		Expect(err).NotTo(HaveOccurred())
+
+		buf = &bytes.Buffer{}
+		trustedCAs = cacerts.NewTrustedCACerts([]string{goDaddy}, false)
+		trustedCAs.CertSources = map[string]string{goDaddy: "some-binding"}
+		trustedCAs.Logger = bard.NewLogger(buf)
+	})
+
+	it("writes the certificates of the layer to report.json", func() {
+		layer, err := trustedCAs.Contribute(layer)
+		Expect(err).NotTo(HaveOccurred())
+
+		report, err := cacerts.ReadReport(filepath.Join(layer.Path, "report.json"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Certificates).To(HaveLen(1))
+
+		c := report.Certificates[0]
+		Expect(c.Subject).To(Equal("OU=Go Daddy Class 2 Certification Authority,O=The Go Daddy Group\\, Inc.,C=US"))
+		Expect(c.Issuer).To(Equal(c.Subject))
+		Expect(c.Fingerprint).To(Equal("c3846bf24b9e93ca64274c0ec67c1ecc5e024ffcacd2d74019350e81fe546ae4"))
+		Expect(c.NotBefore).To(Equal(time.Date(2004, 6, 29, 17, 6, 20, 0, time.UTC)))
+		Expect(c.NotAfter).To(Equal(time.Date(2034, 6, 29, 17, 6, 20, 0, time.UTC)))
+		Expect(c.HashLink).To(Equal(filepath.Join(layer.Path, "ca-certificates", "f081611a.0")))
+		Expect(c.File).To(Equal(goDaddy))
+		Expect(c.Source).To(Equal("some-binding"))
+		Expect(c.Embedded).To(BeFalse())
+
+		Expect(layer.LaunchEnvironment).NotTo(HaveKey("BPL_CA_CERTS_REPORT.override"))
+	})
+
+	it("logs the certificates as a table", func() {
+		_, err := trustedCAs.Contribute(layer)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(buf.String()).To(MatchRegexp(`SUBJECT\s+ISSUER\s+FINGERPRINT\s+NOT AFTER\s+SOURCE\s+EMBEDDED`))
+		Expect(buf.String()).To(MatchRegexp(`c3846bf24b9e93ca64274c0ec67c1ecc5e024ffcacd2d74019350e81fe546ae4\s+2034-06-29\s+some-binding\s+false`))
+	})
+
+	context("embedded", func() {
+		it.Before(func() {
+			trustedCAs.EmbeddedCerts = true
+		})
+
+		it("points the helper to the report", func() {
+			layer, err := trustedCAs.Contribute(layer)
+			Expect(err).NotTo(HaveOccurred())
+
+			path := filepath.Join(layer.Path, "report.json")
+			Expect(layer.LaunchEnvironment["BPL_CA_CERTS_REPORT.override"]).To(Equal(path))
+
+			report, err := cacerts.ReadReport(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Certificates[0].Embedded).To(BeTrue())
+			Expect(report.Certificates[0].File).To(Equal(filepath.Join(layer.Path, "embedded-certs", "Go_Daddy_Class_2_CA.pem")))
+			Expect(report.Certificates[0].Source).To(Equal("some-binding"))
+		})
+	})
+}
@@ -0,0 +1,250 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/buildpacks/libcnb"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+// Keys of the layer metadata of TrustedCACerts. The metadata describes everything the layer contains, so that a layer
+// restored from a previous build is only reused if its contents are unchanged.
+const (
+	// MetadataCertificates is the key of the subjects of the certificates in the layer, keyed by fingerprint.
+	MetadataCertificates = "certificates"
+
+	// MetadataPaths is the key of the fingerprints of the certificates in each file, keyed by path.
+	MetadataPaths = "paths"
+
+	// MetadataProcessPaths is the key of the paths of the files trusted by each process type, keyed by process type.
+	MetadataProcessPaths = "process-paths"
+
+	// MetadataBindings is the key of the names of the bindings that provided the files, keyed by path.
+	MetadataBindings = "bindings"
+
+	// MetadataSources is the key of the sources of the files, keyed by path.
+	MetadataSources = "sources"
+)
+
+// metadata returns the layer metadata describing the certificates in CertPaths and ProcessCertPaths. Files that Build
+// split into the staging directory are recorded relative to SplitCertsDir, as the staging directory differs between
+// builds.
+func (l TrustedCACerts) metadata() (map[string]interface{}, error) {
+	key := func(path string) string {
+		if l.staging.contains(path) {
+			return filepath.Join(SplitCertsDir, filepath.Base(path))
+		}
+		return path
+	}
+
+	certs := map[string]string{}
+	paths := map[string][]string{}
+	bindings := map[string]string{}
+	sources := map[string]string{}
+	add := func(path string) error {
+		if _, ok := paths[key(path)]; ok {
+			return nil
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read file at path %q\n%w", path, err)
+		}
+		decoded, err := truststore.DecodeCertificates(raw)
+		if err != nil {
+			return fmt.Errorf("failed to decode certificates from file at path %q\n%w", path, err)
+		}
+		fingerprints := []string{}
+		for _, c := range decoded {
+			fingerprint := truststore.Fingerprint(c)
+			certs[fingerprint] = c.Subject.String()
+			fingerprints = append(fingerprints, fingerprint)
+		}
+		paths[key(path)] = fingerprints
+		if b, ok := l.CertBindings[path]; ok {
+			bindings[key(path)] = b
+		}
+		if s, ok := l.CertSources[path]; ok {
+			sources[key(path)] = s
+		}
+		return nil
+	}
+
+	for _, path := range l.CertPaths {
+		if err := add(path); err != nil {
+			return nil, err
+		}
+	}
+	processPaths := map[string][]string{}
+	for processType, ps := range l.ProcessCertPaths {
+		keys := []string{}
+		for _, path := range ps {
+			if err := add(path); err != nil {
+				return nil, err
+			}
+			keys = append(keys, key(path))
+		}
+		sort.Strings(keys)
+		processPaths[processType] = keys
+	}
+
+	return map[string]interface{}{
+		MetadataCertificates: certs,
+		MetadataPaths:        paths,
+		MetadataProcessPaths: processPaths,
+		MetadataBindings:     bindings,
+		MetadataSources:      sources,
+	}, nil
+}
+
+// PersistentMetadataCertificates is the key of the persistent metadata recording the subjects of the certificates
+// trusted at build time and at launch time, keyed by scope and fingerprint. Build records it even if it contributes no
+// certificates, so that the next build can tell the certificates that were removed or moved to another scope.
+const PersistentMetadataCertificates = "certificates"
+
+// trustedCerts are the subjects of the certificates trusted at build time and at launch time, keyed by fingerprint.
+type trustedCerts struct {
+	build  map[string]string
+	launch map[string]string
+}
+
+// newTrustedCerts returns the certificates of stores trusted at build time and at launch time.
+func newTrustedCerts(trusts []trust, stores map[trust]*truststore.Truststore) trustedCerts {
+	certs := trustedCerts{build: map[string]string{}, launch: map[string]string{}}
+	for _, t := range trusts {
+		for _, c := range stores[t].Certificates() {
+			if t.build {
+				certs.build[c.Fingerprint] = c.Subject.String()
+			}
+			if t.launch {
+				certs.launch[c.Fingerprint] = c.Subject.String()
+			}
+		}
+	}
+	return certs
+}
+
+// metadata returns the certificates in the format of the persistent metadata.
+func (t trustedCerts) metadata() map[string]interface{} {
+	return map[string]interface{}{
+		ScopeBuild:  t.build,
+		ScopeLaunch: t.launch,
+	}
+}
+
+// previousTrustedCerts returns the certificates trusted by the previous build, as recorded in the persistent metadata.
+// Builds that did not record them are diffed against the metadata of the layers they contributed. ok is false if
+// neither is available, e.g. because there is no previous build.
+func previousTrustedCerts(context libcnb.BuildContext) (_ trustedCerts, ok bool, err error) {
+	certs := trustedCerts{build: map[string]string{}, launch: map[string]string{}}
+
+	if raw, ok := context.PersistentMetadata[PersistentMetadataCertificates].(map[string]interface{}); ok {
+		subjects(raw[ScopeBuild], certs.build)
+		subjects(raw[ScopeLaunch], certs.launch)
+		return certs, true, nil
+	}
+
+	for _, name := range []string{"ca-certificates", "ca-certificates-launch"} {
+		layer, err := context.Layers.Layer(name)
+		if err != nil {
+			return trustedCerts{}, false, err
+		}
+		if _, found := layer.Metadata[MetadataCertificates]; !found {
+			continue
+		}
+		ok = true
+		if layer.Build {
+			subjects(layer.Metadata[MetadataCertificates], certs.build)
+		}
+		if layer.Launch {
+			subjects(layer.Metadata[MetadataCertificates], certs.launch)
+		}
+	}
+	return certs, ok, nil
+}
+
+// subjects adds the subjects keyed by fingerprint in raw, as decoded from TOML or as recorded by trustedCerts.metadata,
+// to m.
+func subjects(raw interface{}, m map[string]string) {
+	switch raw := raw.(type) {
+	case map[string]string:
+		for fingerprint, subject := range raw {
+			m[fingerprint] = subject
+		}
+	case map[string]interface{}:
+		for fingerprint, subject := range raw {
+			m[fingerprint], _ = subject.(string)
+		}
+	}
+}
+
+// diff logs the certificates added and removed at build time and at launch time since the previous build. If locked is
+// true, any change is an error.
+func (b Build) diff(previous trustedCerts, current trustedCerts, locked bool) error {
+	var changes []string
+	for _, scope := range []struct {
+		name              string
+		previous, current map[string]string
+	}{
+		{ScopeBuild, previous.build, current.build},
+		{ScopeLaunch, previous.launch, current.launch},
+	} {
+		if len(scope.previous) == 0 && len(scope.current) == 0 {
+			continue
+		}
+
+		var added, removed []string
+		unchanged := 0
+		for fingerprint, subject := range scope.current {
+			if _, ok := scope.previous[fingerprint]; ok {
+				unchanged++
+			} else {
+				added = append(added, fmt.Sprintf("%q (%s)", subject, fingerprint))
+			}
+		}
+		for fingerprint, subject := range scope.previous {
+			if _, ok := scope.current[fingerprint]; !ok {
+				removed = append(removed, fmt.Sprintf("%q (%s)", subject, fingerprint))
+			}
+		}
+		sort.Strings(added)
+		sort.Strings(removed)
+
+		for _, c := range added {
+			b.Logger.Bodyf("Added CA certificate %s at %s time since the previous build", c, scope.name)
+			changes = append(changes, fmt.Sprintf("added %s at %s time", c, scope.name))
+		}
+		for _, c := range removed {
+			b.Logger.Bodyf("Removed CA certificate %s at %s time since the previous build", c, scope.name)
+			changes = append(changes, fmt.Sprintf("removed %s at %s time", c, scope.name))
+		}
+		b.Logger.Bodyf("%d CA certificate(s) added, %d removed and %d unchanged at %s time since the previous build",
+			len(added), len(removed), unchanged, scope.name)
+	}
+
+	if locked && len(changes) > 0 {
+		return fmt.Errorf("trusted CA certificates changed since the previous build and BP_CA_CERTS_LOCKED is true: %s",
+			strings.Join(changes, ", "))
+	}
+	return nil
+}
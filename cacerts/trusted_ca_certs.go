@@ -20,54 +20,150 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/buildpacks/libcnb"
 
 	"github.com/paketo-buildpacks/libpak"
 	"github.com/paketo-buildpacks/libpak/bard"
 	"github.com/paketo-buildpacks/libpak/sherpa"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
 )
 
 const (
 	CACertsDir    = "ca-certificates"
 	EmbedCertsDir = "embedded-certs"
+
+	// SplitCertsDir is the directory, relative to a layer that does not embed certificates, containing the
+	// certificates that Build split from bundles or inline PEM data.
+	SplitCertsDir = "split-certs"
 )
 
+// stagingDir is a temporary directory containing the certificates split by Build. Layers copy the certificates they
+// trust from it and the directory is removed once the last layer has been contributed.
+type stagingDir struct {
+	path   string
+	layers int
+}
+
+func newStagingDir() (*stagingDir, error) {
+	path, err := truststore.MkdirTemp("", "ca-certificates")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create temporary directory for certificates\n%w", err)
+	}
+	return &stagingDir{path: path}, nil
+}
+
+// add makes l copy the certificates it trusts from the directory.
+func (s *stagingDir) add(l *TrustedCACerts) {
+	s.layers++
+	l.staging = s
+}
+
+// contains returns true if path is in the directory.
+func (s *stagingDir) contains(path string) bool {
+	return s != nil && filepath.Dir(path) == s.path
+}
+
+// release removes the directory once it has been released by all layers, or right away if a layer failed, as the
+// build fails and the remaining layers are not contributed.
+func (s *stagingDir) release(failed bool) error {
+	if s == nil {
+		return nil
+	}
+	if s.layers--; s.layers > 0 && !failed {
+		return nil
+	}
+	if err := os.RemoveAll(s.path); err != nil {
+		return fmt.Errorf("failed to remove %q\n%w", s.path, err)
+	}
+	return nil
+}
+
 type TrustedCACerts struct {
 	CertPaths         []string
+	ProcessCertPaths  map[string][]string
+	CertBindings      map[string]string
+	CertSources       map[string]string
 	EmbeddedCerts     bool
+	LaunchOnly        bool
 	GenerateHashLinks func(dir string, certPaths []string) error
 	LayerContributor  libpak.LayerContributor
 	Logger            bard.Logger
+
+	staging *stagingDir
 }
 
+// NewTrustedCACerts creates a layer that trusts the certificates at paths at build time and, if embedCACerts is true,
+// at launch time. The layer is cached, so that its metadata, and with it the certificates of the previous build, is
+// restored even if it is not a launch layer. Build falls back to it for builds that did not record
+// PersistentMetadataCertificates.
 func NewTrustedCACerts(paths []string, embedCACerts bool) *TrustedCACerts {
 	return &TrustedCACerts{
 		CertPaths:         paths,
-		GenerateHashLinks: GenerateHashLinks,
+		GenerateHashLinks: truststore.SyncHashLinks,
 		EmbeddedCerts:     embedCACerts,
 		LayerContributor: libpak.NewLayerContributor(
 			"CA Certificates",
 			map[string]interface{}{},
 			libcnb.LayerTypes{
 				Build:  true,
+				Cache:  true,
 				Launch: embedCACerts,
 			},
 		),
 	}
 }
 
-// Contribute create build layer adding the certificates at Layer.CAPaths to the set of trusted CAs.
-func (l TrustedCACerts) Contribute(layer libcnb.Layer) (libcnb.Layer, error) {
+// NewLaunchTrustedCACerts creates a launch layer that embeds the certificates at paths without trusting them at build
+// time.
+func NewLaunchTrustedCACerts(paths []string) *TrustedCACerts {
+	return &TrustedCACerts{
+		CertPaths:         paths,
+		GenerateHashLinks: truststore.SyncHashLinks,
+		EmbeddedCerts:     true,
+		LaunchOnly:        true,
+		LayerContributor: libpak.NewLayerContributor(
+			"Launch CA Certificates",
+			map[string]interface{}{},
+			libcnb.LayerTypes{
+				Launch: true,
+			},
+		),
+	}
+}
+
+// Contribute create build layer adding the certificates at Layer.CAPaths to the set of trusted CAs. The staging
+// directory is released whether or not the layer could be contributed.
+func (l TrustedCACerts) Contribute(layer libcnb.Layer) (_ libcnb.Layer, err error) {
+	defer func() {
+		if rerr := l.staging.release(err != nil); rerr != nil && err == nil {
+			err = rerr
+		}
+	}()
+
 	l.LayerContributor.Logger = l.Logger
 
-	return l.LayerContributor.Contribute(layer, func() (libcnb.Layer, error) {
+	metadata, err := l.metadata()
+	if err != nil {
+		return libcnb.Layer{}, err
+	}
+	l.LayerContributor.ExpectedMetadata = metadata
+
+	layer, err = l.LayerContributor.Contribute(layer, func() (libcnb.Layer, error) {
 		certsDir := filepath.Join(layer.Path, CACertsDir)
 
-		if err := os.Mkdir(certsDir, 0755); err != nil {
+		if err := os.Mkdir(certsDir, truststore.DirMode); err != nil {
 			return libcnb.Layer{}, fmt.Errorf("failed to create directory %q\n%w", certsDir, err)
 		}
 
+		if !l.EmbeddedCerts {
+			if err := l.copySplitCerts(layer); err != nil {
+				return libcnb.Layer{}, err
+			}
+		}
+
 		if l.EmbeddedCerts {
 			if err := l.ContributeEmbedCACerts(layer); err != nil {
 				return libcnb.Layer{}, err
@@ -75,12 +171,25 @@ func (l TrustedCACerts) Contribute(layer libcnb.Layer) (libcnb.Layer, error) {
 
 			layer.LaunchEnvironment.Append(EnvCAPath, string(filepath.ListSeparator), certsDir)
 			layer.LaunchEnvironment.Default(EnvCAFile, DefaultCAFile)
+
+			if err := l.contributeProcessCACerts(layer); err != nil {
+				return libcnb.Layer{}, err
+			}
 		}
 
 		if err := l.GenerateHashLinks(certsDir, l.CertPaths); err != nil {
 			return libcnb.Layer{}, fmt.Errorf("failed to generate CA certificate symlinks\n%w", err)
 		}
 
+		if err := l.contributeReport(layer); err != nil {
+			return libcnb.Layer{}, err
+		}
+
+		if l.LaunchOnly {
+			l.Logger.Bodyf("Added %d additional CA certificate(s) to system truststore at launch", len(l.CertPaths))
+			return layer, nil
+		}
+
 		l.Logger.Bodyf("Added %d additional CA certificate(s) to system truststore", len(l.CertPaths))
 
 		layer.BuildEnvironment.Append(
@@ -92,35 +201,153 @@ func (l TrustedCACerts) Contribute(layer libcnb.Layer) (libcnb.Layer, error) {
 
 		return layer, nil
 	})
+	if err != nil {
+		return libcnb.Layer{}, err
+	}
+	return layer, nil
+}
+
+// copySplitCerts copies the certificates in CertPaths that Build split from bundles or inline PEM data from the
+// staging directory into the layer and updates their paths.
+func (l *TrustedCACerts) copySplitCerts(layer libcnb.Layer) error {
+	var paths []string
+	copied := map[string]string{}
+	for _, path := range l.CertPaths {
+		if !l.staging.contains(path) {
+			paths = append(paths, path)
+			continue
+		}
+
+		dest := filepath.Join(layer.Path, SplitCertsDir, filepath.Base(path))
+		if err := copyCert(path, dest); err != nil {
+			return err
+		}
+		copied[path] = dest
+		paths = append(paths, dest)
+	}
+	l.CertPaths = paths
+	l.moved(copied)
+	return nil
+}
+
+// moved updates CertBindings and CertSources for certificates that were copied, keyed by their original paths.
+func (l *TrustedCACerts) moved(copies map[string]string) {
+	rekey := func(m map[string]string) map[string]string {
+		result := map[string]string{}
+		for path, v := range m {
+			if dest, ok := copies[path]; ok {
+				path = dest
+			}
+			result[path] = v
+		}
+		return result
+	}
+	l.CertBindings = rekey(l.CertBindings)
+	l.CertSources = rekey(l.CertSources)
+}
+
+// copyCert copies the certificate at path to dest with truststore.FileMode.
+func copyCert(path string, dest string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open cert %q\n%w", path, err)
+	}
+	defer in.Close()
+
+	if err := sherpa.CopyFile(in, dest); err != nil {
+		return fmt.Errorf("failed to copy cert %q to %q\n%w", path, dest, err)
+	}
+	if err := os.Chmod(dest, truststore.FileMode); err != nil {
+		return fmt.Errorf("failed to change mode of %q\n%w", dest, err)
+	}
+	return nil
 }
 
 func (l *TrustedCACerts) ContributeEmbedCACerts(layer libcnb.Layer) error {
 	l.Logger.Body("Embedding CA certificate(s)")
 
 	embeddedDir := filepath.Join(layer.Path, EmbedCertsDir)
-	if err := os.Mkdir(embeddedDir, 0755); err != nil {
+	if err := os.Mkdir(embeddedDir, truststore.DirMode); err != nil {
 		return fmt.Errorf("failed to create directory %q\n%w", embeddedDir, err)
 	}
 
-	newCertPaths := []string{}
-	for _, certPath := range l.CertPaths {
-		certFile, err := os.Open(certPath)
-		if err != nil {
-			return fmt.Errorf("failed to open cert %q\n%w", certPath, err)
+	embedded := map[string]string{}
+	embed := func(paths []string) ([]string, error) {
+		newCertPaths := []string{}
+		for _, certPath := range paths {
+			if dest, ok := embedded[certPath]; ok {
+				newCertPaths = append(newCertPaths, dest)
+				continue
+			}
+
+			dest := filepath.Join(embeddedDir, filepath.Base(certPath))
+			if _, err := os.Stat(dest); err == nil {
+				dest = filepath.Join(embeddedDir, fmt.Sprintf("%d_%s", len(embedded), filepath.Base(certPath)))
+			}
+			if err := copyCert(certPath, dest); err != nil {
+				return nil, err
+			}
+			embedded[certPath] = dest
+			newCertPaths = append(newCertPaths, dest)
 		}
+		return newCertPaths, nil
+	}
 
-		dest := filepath.Join(embeddedDir, filepath.Base(certPath))
-		err = sherpa.CopyFile(certFile, dest)
-		if err != nil {
-			return fmt.Errorf("failed to copy cert %q to %q\n%w", certPath, dest, err)
+	var err error
+	if l.CertPaths, err = embed(l.CertPaths); err != nil {
+		return err
+	}
+	processCertPaths := map[string][]string{}
+	for processType, paths := range l.ProcessCertPaths {
+		if processCertPaths[processType], err = embed(paths); err != nil {
+			return err
 		}
-		newCertPaths = append(newCertPaths, dest)
 	}
-	l.CertPaths = newCertPaths
+	l.ProcessCertPaths = processCertPaths
+	l.moved(embedded)
+
+	record, err := l.embeddedCerts(layer.Path)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(layer.Path, EmbeddedCertsFile)
+	if err := record.Write(file); err != nil {
+		return err
+	}
+	layer.LaunchEnvironment.Override(EnvEmbeddedCerts, file)
 
 	return nil
 }
 
-func (TrustedCACerts) Name() string {
+// contributeProcessCACerts creates a directory of hash links for each process type in ProcessCertPaths and appends it
+// to the launch environment of the process type.
+func (l *TrustedCACerts) contributeProcessCACerts(layer libcnb.Layer) error {
+	var processTypes []string
+	for processType := range l.ProcessCertPaths {
+		processTypes = append(processTypes, processType)
+	}
+	sort.Strings(processTypes)
+
+	for _, processType := range processTypes {
+		dir := filepath.Join(layer.Path, ProcessCertsDir, processType)
+		if err := os.MkdirAll(dir, truststore.DirMode); err != nil {
+			return fmt.Errorf("failed to create directory %q\n%w", dir, err)
+		}
+		if err := l.GenerateHashLinks(dir, l.ProcessCertPaths[processType]); err != nil {
+			return fmt.Errorf("failed to generate CA certificate symlinks for process type %q\n%w", processType, err)
+		}
+		l.Logger.Bodyf("Added %d additional CA certificate(s) to system truststore of process type %q",
+			len(l.ProcessCertPaths[processType]), processType)
+
+		layer.LaunchEnvironment.ProcessAppend(processType, EnvCAPath, string(filepath.ListSeparator), dir)
+		layer.LaunchEnvironment.ProcessOverride(processType, EnvProcessType, processType)
+	}
+	return nil
+}
+
+func (l TrustedCACerts) Name() string {
+	if l.LaunchOnly {
+		return "ca-certificates-launch"
+	}
 	return "ca-certificates"
 }
@@ -96,6 +96,15 @@ func testTrustedCACerts(t *testing.T, context spec.G, it spec.S) {
 			Expect(layer.LaunchEnvironment).To(BeEmpty())
 		})
 
+		it("caches the layer", func() {
+			layer, err := trustedCAs.Contribute(layer)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(layer.Build).To(BeTrue())
+			Expect(layer.Cache).To(BeTrue())
+			Expect(layer.Launch).To(BeFalse())
+		})
+
 		it("sets SSL_CERT_FILE to stack default", func() {
 			layer, err := trustedCAs.Contribute(layer)
 			Expect(err).NotTo(HaveOccurred())
@@ -119,12 +128,106 @@ func testTrustedCACerts(t *testing.T, context spec.G, it spec.S) {
 			Expect(certDir).To(Equal(filepath.Join(layer.Path, "ca-certificates")))
 		})
 
+		context("launch only", func() {
+			it.Before(func() {
+				trustedCAs = cacerts.NewLaunchTrustedCACerts(caCertsList)
+				trustedCAs.GenerateHashLinks = generateHashLinks
+			})
+
+			it("only sets the launch environment", func() {
+				layer, err := trustedCAs.Contribute(layer)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(layer.Build).To(BeFalse())
+				Expect(layer.Launch).To(BeTrue())
+				Expect(layer.BuildEnvironment).To(BeEmpty())
+				Expect(layer.LaunchEnvironment["SSL_CERT_DIR.append"]).
+					To(Equal(filepath.Join(layer.Path, "ca-certificates")))
+				for _, caCert := range caCertsList {
+					Expect(filepath.Join(layer.Path, "embedded-certs", filepath.Base(caCert))).To(BeARegularFile())
+				}
+			})
+		})
+
+		context("process types", func() {
+			it.Before(func() {
+				trustedCAs = cacerts.NewLaunchTrustedCACerts(caCertsList[:1])
+				trustedCAs.ProcessCertPaths = map[string][]string{"web": caCertsList[1:]}
+				trustedCAs.GenerateHashLinks = generateHashLinks
+			})
+
+			it("writes the launch environment of the process type", func() {
+				layer, err := trustedCAs.Contribute(layer)
+				Expect(err).NotTo(HaveOccurred())
+
+				processDir := filepath.Join(layer.Path, "process-certs", "web")
+				Expect(processDir).To(BeADirectory())
+				Expect(layer.LaunchEnvironment["SSL_CERT_DIR.append"]).
+					To(Equal(filepath.Join(layer.Path, "ca-certificates")))
+				Expect(layer.LaunchEnvironment["web/SSL_CERT_DIR.append"]).To(Equal(processDir))
+				Expect(layer.LaunchEnvironment["web/BPL_CA_CERTS_PROCESS_TYPE.override"]).To(Equal("web"))
+
+				Expect(called).To(Equal(2))
+				Expect(certDir).To(Equal(filepath.Join(layer.Path, "ca-certificates")))
+				for _, caCert := range caCertsList {
+					Expect(filepath.Join(layer.Path, "embedded-certs", filepath.Base(caCert))).To(BeARegularFile())
+				}
+			})
+		})
+
 		context("embed certs at launch", func() {
 			it.Before(func() {
 				trustedCAs = cacerts.NewTrustedCACerts(caCertsList, true)
 				trustedCAs.GenerateHashLinks = generateHashLinks
 			})
 
+			it("records the embedded certificates and their bindings", func() {
+				cert := filepath.Join("testdata", "SecureTrust_CA.pem")
+				trustedCAs.CertPaths = []string{cert}
+				trustedCAs.CertBindings = map[string]string{cert: "corp-ca"}
+
+				layer, err := trustedCAs.Contribute(layer)
+				Expect(err).NotTo(HaveOccurred())
+
+				record := filepath.Join(layer.Path, "embedded-certs.json")
+				Expect(layer.LaunchEnvironment["BPL_CA_CERTS_EMBEDDED.override"]).To(Equal(record))
+				embedded, err := cacerts.ReadEmbeddedCerts(record)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(embedded).To(Equal(cacerts.EmbeddedCerts{
+					Dir: filepath.Join(layer.Path, "ca-certificates"),
+					Certificates: []cacerts.EmbeddedCert{{
+						Fingerprint: "f1c1b50ae5a20dd8030ec9f6bc24823dd367b5255759b4e71b61fce9f7375d73",
+						Subject:     "CN=SecureTrust CA,O=SecureTrust Corporation,C=US",
+						Binding:     "corp-ca",
+						Path:        filepath.Join(layer.Path, "embedded-certs", "SecureTrust_CA.pem"),
+					}},
+				}))
+			})
+
+			it("records the embedded certificates of process types", func() {
+				cert := filepath.Join("testdata", "SecureTrust_CA.pem")
+				trustedCAs.CertPaths = []string{}
+				trustedCAs.ProcessCertPaths = map[string][]string{"web": {cert}}
+				trustedCAs.CertBindings = map[string]string{cert: "web-ca"}
+
+				layer, err := trustedCAs.Contribute(layer)
+				Expect(err).NotTo(HaveOccurred())
+
+				embedded, err := cacerts.ReadEmbeddedCerts(filepath.Join(layer.Path, "embedded-certs.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(embedded).To(Equal(cacerts.EmbeddedCerts{
+					Dir:         filepath.Join(layer.Path, "ca-certificates"),
+					ProcessDirs: map[string]string{"web": filepath.Join(layer.Path, "process-certs", "web")},
+					Certificates: []cacerts.EmbeddedCert{{
+						Fingerprint: "f1c1b50ae5a20dd8030ec9f6bc24823dd367b5255759b4e71b61fce9f7375d73",
+						Subject:     "CN=SecureTrust CA,O=SecureTrust Corporation,C=US",
+						Binding:     "web-ca",
+						Path:        filepath.Join(layer.Path, "embedded-certs", "SecureTrust_CA.pem"),
+						ProcessType: "web",
+					}},
+				}))
+			})
+
 			it("copies ca-certs", func() {
 				layer, err := trustedCAs.Contribute(layer)
 				Expect(err).NotTo(HaveOccurred())
@@ -134,6 +237,28 @@ func testTrustedCACerts(t *testing.T, context spec.G, it spec.S) {
 				}
 			})
 
+			it("makes the copies readable but not writable by other users", func() {
+				Expect(os.Chmod(caCertsList[0], 0600)).To(Succeed())
+				Expect(os.Chmod(caCertsList[1], 0777)).To(Succeed())
+
+				layer, err := trustedCAs.Contribute(layer)
+				Expect(err).NotTo(HaveOccurred())
+
+				for _, path := range []string{
+					filepath.Join(layer.Path, "ca-certificates"),
+					filepath.Join(layer.Path, "embedded-certs"),
+				} {
+					info, err := os.Stat(path)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+				}
+				for _, caCert := range caCertsList {
+					info, err := os.Stat(filepath.Join(layer.Path, "embedded-certs", filepath.Base(caCert)))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(info.Mode().Perm()).To(Equal(os.FileMode(0644)))
+				}
+			})
+
 			it("appends to SSL_CERT_DIR", func() {
 				layer, err := trustedCAs.Contribute(layer)
 				Expect(err).NotTo(HaveOccurred())
@@ -164,5 +289,26 @@ func testTrustedCACerts(t *testing.T, context spec.G, it spec.S) {
 					To(Equal("/etc/ssl/certs/ca-certificates.crt"))
 			})
 		})
+
+		context("metadata", func() {
+			const goDaddy = "c3846bf24b9e93ca64274c0ec67c1ecc5e024ffcacd2d74019350e81fe546ae4"
+
+			it.Before(func() {
+				trustedCAs = cacerts.NewTrustedCACerts([]string{filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem")}, true)
+				trustedCAs.GenerateHashLinks = generateHashLinks
+			})
+
+			it("records the certificates in the layer metadata", func() {
+				layer, err := trustedCAs.Contribute(layer)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(layer.Metadata).To(HaveKeyWithValue("certificates", map[string]interface{}{
+					goDaddy: "OU=Go Daddy Class 2 Certification Authority,O=The Go Daddy Group\\, Inc.,C=US",
+				}))
+				Expect(layer.Metadata).To(HaveKeyWithValue("paths", map[string]interface{}{
+					filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"): []interface{}{goDaddy},
+				}))
+			})
+		})
 	})
 }
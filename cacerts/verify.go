@@ -0,0 +1,187 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+// CommandVerify is the helper command that verifies a certificate chain against the effective truststore.
+const CommandVerify = "verify"
+
+// Verifier verifies certificate chains against the certificates reachable through SSL_CERT_FILE and SSL_CERT_DIR.
+type Verifier struct {
+	// Inspector provides the trusted certificates.
+	Inspector Inspector
+
+	// Now returns the time at which certificates are verified.
+	Now func() time.Time
+}
+
+// NewVerifier creates a Verifier for the truststore seen by inspector.
+func NewVerifier(inspector Inspector) Verifier {
+	return Verifier{Inspector: inspector, Now: time.Now}
+}
+
+// Verify verifies a PEM encoded chain, starting with the leaf certificate, and, if not empty, that the leaf
+// certificate is valid for hostname. It returns the first verified chain, ending with the trusted certificate. If
+// verification fails, the returned error explains why.
+func (v Verifier) Verify(chain []byte, hostname string) ([]*x509.Certificate, error) {
+	certs, err := truststore.DecodeCertificates(chain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode chain\n%w", err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("chain does not contain any PEM encoded certificates")
+	}
+
+	trusted, err := v.Inspector.Certificates()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load truststore\n%w", err)
+	}
+	roots := x509.NewCertPool()
+	for _, c := range trusted {
+		if !c.Dangling {
+			roots.AddCert(c.Certificate)
+		}
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+
+	now := v.Now()
+	chains, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       hostname,
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s\n%w", explain(err, certs, trusted, hostname, now), err)
+	}
+	return chains[0], nil
+}
+
+// Write verifies the chain and writes the verified chain to w.
+func (v Verifier) Write(w io.Writer, chain []byte, hostname string) error {
+	verified, err := v.Verify(chain, hostname)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintln(&b, "Chain is trusted:")
+	for i, c := range verified {
+		fmt.Fprintf(&b, "  %d: %s\n", i, c.Subject)
+	}
+	if hostname != "" {
+		fmt.Fprintf(&b, "Certificate is valid for hostname %q\n", hostname)
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// explain returns a description of why chain failed to verify.
+func explain(err error, chain []*x509.Certificate, trusted []TrustedCertificate, hostname string, now time.Time) string {
+	var (
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+		authorityErr x509.UnknownAuthorityError
+	)
+
+	switch {
+	case errors.As(err, &hostnameErr):
+		names := hostnameErr.Certificate.DNSNames
+		for _, ip := range hostnameErr.Certificate.IPAddresses {
+			names = append(names, ip.String())
+		}
+		return fmt.Sprintf("certificate %q is not valid for hostname %q, it is valid for [%s]",
+			hostnameErr.Certificate.Subject, hostname, strings.Join(names, ", "))
+
+	case errors.As(err, &invalidErr):
+		if invalidErr.Reason == x509.Expired {
+			return validity(invalidErr.Cert, now)
+		}
+		return fmt.Sprintf("certificate %q is invalid", invalidErr.Cert.Subject)
+
+	case errors.As(err, &authorityErr):
+		// Expired intermediates are reported as an unknown authority, so check the chain first
+		for _, c := range chain[1:] {
+			if !isValidAt(c, now) {
+				return validity(c, now)
+			}
+		}
+
+		top := topOfChain(chain)
+		for _, t := range trusted {
+			c := t.Certificate
+			if !t.Dangling && bytes.Equal(c.RawSubject, top.RawIssuer) && top.CheckSignatureFrom(c) == nil && !isValidAt(c, now) {
+				return "trusted " + validity(c, now)
+			}
+		}
+		if bytes.Equal(top.RawSubject, top.RawIssuer) && top.CheckSignature(top.SignatureAlgorithm, top.RawTBSCertificate, top.Signature) == nil {
+			return fmt.Sprintf("root certificate %q is not in the truststore", top.Subject)
+		}
+		return fmt.Sprintf("certificate %q is issued by %q which is neither in the truststore nor included in the chain, "+
+			"the chain may be missing an intermediate certificate", top.Subject, top.Issuer)
+
+	default:
+		return "failed to verify chain"
+	}
+}
+
+// topOfChain follows the issuers of the leaf certificate within chain and returns the last certificate found.
+func topOfChain(chain []*x509.Certificate) *x509.Certificate {
+	top := chain[0]
+	visited := map[*x509.Certificate]bool{top: true}
+	for {
+		var issuer *x509.Certificate
+		for _, c := range chain {
+			if !visited[c] && bytes.Equal(c.RawSubject, top.RawIssuer) && top.CheckSignatureFrom(c) == nil {
+				issuer = c
+				break
+			}
+		}
+		if issuer == nil {
+			return top
+		}
+		visited[issuer] = true
+		top = issuer
+	}
+}
+
+func validity(cert *x509.Certificate, now time.Time) string {
+	if now.Before(cert.NotBefore) {
+		return fmt.Sprintf("certificate %q is not valid before %s", cert.Subject, cert.NotBefore.UTC().Format(time.RFC3339))
+	}
+	return fmt.Sprintf("certificate %q expired at %s", cert.Subject, cert.NotAfter.UTC().Format(time.RFC3339))
+}
+
+func isValidAt(cert *x509.Certificate, now time.Time) bool {
+	return !now.Before(cert.NotBefore) && !now.After(cert.NotAfter)
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/cacerts"
+)
+
+func testVerifier(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		env      map[string]string
+		verifier cacerts.Verifier
+
+		leaf, intermediate []byte
+	)
+
+	it.Before(func() {
+		env = map[string]string{
+			"SSL_CERT_FILE": filepath.Join("testdata", "chain", "root.pem"),
+			"SSL_CERT_DIR":  t.TempDir(),
+		}
+		execd := cacerts.NewExecD(nil)
+		execd.GetEnv = func(k string) string { return env[k] }
+		verifier = cacerts.NewVerifier(cacerts.Inspector{ExecD: execd})
+
+		var err error
+		leaf, err = os.ReadFile(filepath.Join("testdata", "chain", "leaf.pem"))
+		Expect(err).NotTo(HaveOccurred())
+		intermediate, err = os.ReadFile(filepath.Join("testdata", "chain", "intermediate.pem"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	it("verifies a trusted chain", func() {
+		chain, err := verifier.Verify(append(leaf, intermediate...), "www.example.com")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(chain).To(HaveLen(3))
+		Expect(chain[2].Subject.CommonName).To(Equal("Test Chain Root CA"))
+	})
+
+	it("ignores dangling hash links", func() {
+		Expect(os.Symlink(filepath.Join(t.TempDir(), "missing.pem"), filepath.Join(env["SSL_CERT_DIR"], "12345678.0"))).To(Succeed())
+
+		_, err := verifier.Verify(append(leaf, intermediate...), "www.example.com")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	it("writes the verified chain", func() {
+		buf := &bytes.Buffer{}
+		Expect(verifier.Write(buf, append(leaf, intermediate...), "example.com")).To(Succeed())
+		Expect(buf.String()).To(Equal(`Chain is trusted:
+  0: CN=example.com
+  1: CN=Test Chain Intermediate CA
+  2: CN=Test Chain Root CA
+Certificate is valid for hostname "example.com"
+`))
+	})
+
+	it("explains an unknown authority", func() {
+		env["SSL_CERT_FILE"] = filepath.Join("testdata", "SecureTrust_CA.pem")
+
+		_, err := verifier.Verify(append(leaf, intermediate...), "")
+		Expect(err).To(MatchError(HavePrefix(`certificate "CN=Test Chain Intermediate CA" is issued by "CN=Test Chain Root CA" which is neither in the truststore nor included in the chain`)))
+	})
+
+	it("explains an untrusted root included in the chain", func() {
+		env["SSL_CERT_FILE"] = filepath.Join("testdata", "SecureTrust_CA.pem")
+		root, err := os.ReadFile(filepath.Join("testdata", "chain", "root.pem"))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = verifier.Verify(append(append(leaf, intermediate...), root...), "")
+		Expect(err).To(MatchError(HavePrefix(`root certificate "CN=Test Chain Root CA" is not in the truststore`)))
+	})
+
+	it("explains a missing intermediate", func() {
+		_, err := verifier.Verify(leaf, "")
+		Expect(err).To(MatchError(HavePrefix(`certificate "CN=example.com" is issued by "CN=Test Chain Intermediate CA" which is neither in the truststore nor included in the chain, the chain may be missing an intermediate certificate`)))
+	})
+
+	it("explains a hostname mismatch", func() {
+		_, err := verifier.Verify(append(leaf, intermediate...), "example.org")
+		Expect(err).To(MatchError(HavePrefix(`certificate "CN=example.com" is not valid for hostname "example.org", it is valid for [example.com, www.example.com]`)))
+	})
+
+	it("explains an expired certificate", func() {
+		verifier.Now = func() time.Time { return time.Now().AddDate(200, 0, 0) }
+
+		_, err := verifier.Verify(append(leaf, intermediate...), "")
+		Expect(err).To(MatchError(MatchRegexp(`^certificate "CN=example.com" expired at \S+\n`)))
+	})
+
+	it("explains a certificate that is not yet valid", func() {
+		verifier.Now = func() time.Time { return time.Now().AddDate(-1, 0, 0) }
+
+		_, err := verifier.Verify(append(leaf, intermediate...), "")
+		Expect(err).To(MatchError(MatchRegexp(`^certificate "CN=example.com" is not valid before \S+\n`)))
+	})
+
+	it("fails if the chain contains no certificates", func() {
+		key, err := os.ReadFile(filepath.Join("..", "truststore", "testdata", "tls-secret", "tls.key"))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = verifier.Verify(key, "")
+		Expect(err).To(MatchError("chain does not contain any PEM encoded certificates"))
+	})
+}
@@ -0,0 +1,252 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/buildpacks/libcnb"
+
+	"github.com/paketo-buildpacks/libpak/bard"
+	"github.com/paketo-buildpacks/libpak/bindings"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+const (
+	// CommandWatch is the helper command that runs a Watcher in the background.
+	CommandWatch = "watch"
+
+	// DefaultWatchDebounce is the time a Watcher waits for further changes before re-syncing the truststore.
+	DefaultWatchDebounce = time.Second
+
+	// DefaultWatchCheckInterval is the interval at which a Watcher checks whether its certificate directory is still
+	// in use.
+	DefaultWatchCheckInterval = time.Minute
+)
+
+// Watcher keeps the runtime certificate directory created by ExecD in sync with the ca-certificates bindings.
+// OpenSSL looks up certificates in SSL_CERT_DIR at handshake time, so updates are picked up by running processes.
+type Watcher struct {
+	// CertDir is the directory containing the hash links, as added to SSL_CERT_DIR by ExecD.
+	CertDir string
+
+	// ExecD provides the configuration used to select and split certificates.
+	ExecD *ExecD
+
+	// LoadBindings loads the current bindings.
+	LoadBindings func() (libcnb.Bindings, error)
+
+	// BindingRoots are directories containing bindings that are watched for added or removed bindings.
+	BindingRoots []string
+
+	// Debounce is the time to wait for further changes before re-syncing.
+	Debounce time.Duration
+
+	// CertDirsInUse returns the directories in SSL_CERT_DIR of the running processes, see ProcessCertDirs.
+	CertDirsInUse func() (map[string]bool, error)
+
+	// CheckInterval is the interval at which the Watcher checks whether CertDir is still in use. If it is not positive,
+	// CertDir is only checked when a binding changes.
+	CheckInterval time.Duration
+
+	Logger bard.Logger
+}
+
+// NewWatcher creates a Watcher for certDir that loads bindings from $SERVICE_BINDING_ROOT or $CNB_BINDINGS.
+func NewWatcher(certDir string) Watcher {
+	var roots []string
+	for _, key := range []string{"SERVICE_BINDING_ROOT", "CNB_BINDINGS"} {
+		if v := os.Getenv(key); v != "" {
+			roots = append(roots, v)
+		}
+	}
+
+	execd := NewExecD(nil)
+	return Watcher{
+		CertDir:       certDir,
+		ExecD:         execd,
+		LoadBindings:  libcnb.NewBindingsForLaunch,
+		BindingRoots:  roots,
+		Debounce:      DefaultWatchDebounce,
+		CertDirsInUse: ProcessCertDirs,
+		CheckInterval: DefaultWatchCheckInterval,
+	}
+}
+
+// Run watches the binding roots and bindings for changes and re-syncs the truststore after each change until stop
+// is closed or CertDir is no longer in use, see inUse.
+func (w Watcher) Run(stop <-chan struct{}) error {
+	var check <-chan time.Time
+	if w.CheckInterval > 0 {
+		ticker := time.NewTicker(w.CheckInterval)
+		defer ticker.Stop()
+		check = ticker.C
+	}
+
+	for first := true; ; first = false {
+		binds, err := w.LoadBindings()
+		if err != nil {
+			return fmt.Errorf("unable to load bindings\n%w", err)
+		}
+
+		// Watch before syncing so that no change between syncing and watching is missed
+		changes, closer, err := watchDirs(w.dirs(binds))
+		if err != nil {
+			return fmt.Errorf("unable to watch bindings\n%w", err)
+		}
+
+		if !first {
+			if _, err := os.Stat(w.CertDir); os.IsNotExist(err) {
+				w.Logger.Infof("Stopped watching CA certificates, %q was removed", w.CertDir)
+				return closer()
+			}
+			// In the json log format the error is part of the summary event
+			if err := w.sync(binds); err != nil && w.ExecD.GetEnv("BPL_CA_CERTS_LOG_FORMAT") != LogFormatJSON {
+				w.Logger.Infof("Failed to update CA certificates\n%s", err)
+			}
+		}
+
+	wait:
+		for {
+			select {
+			case <-stop:
+				return closer()
+			case <-check:
+				if !w.inUse() {
+					w.Logger.Infof("Stopped watching CA certificates, %q is no longer in use", w.CertDir)
+					return closer()
+				}
+			case <-changes:
+				break wait
+			}
+		}
+
+		// Wait until changes have settled, Kubernetes updates a volume in several steps
+		for settled := false; !settled; {
+			select {
+			case <-stop:
+				return closer()
+			case <-changes:
+			case <-time.After(w.Debounce):
+				settled = true
+			}
+		}
+
+		if err := closer(); err != nil {
+			return err
+		}
+	}
+}
+
+// inUse returns false if CertDir was removed or is not in SSL_CERT_DIR of any running process. CertDir is assumed to
+// be in use if the directories in use cannot be determined. The application is started after the exec.d helper
+// that starts the Watcher, so CertDir is only checked once the first CheckInterval has passed.
+func (w Watcher) inUse() bool {
+	if _, err := os.Stat(w.CertDir); os.IsNotExist(err) {
+		return false
+	}
+	if w.CertDirsInUse == nil {
+		return true
+	}
+	dirs, err := w.CertDirsInUse()
+	return err != nil || dirs[filepath.Clean(w.CertDir)]
+}
+
+// Sync re-syncs the truststore with the current bindings.
+func (w Watcher) Sync() error {
+	binds, err := w.LoadBindings()
+	if err != nil {
+		return fmt.Errorf("unable to load bindings\n%w", err)
+	}
+	return w.sync(binds)
+}
+
+// sync re-syncs the truststore with binds. If BPL_CA_CERTS_LOG_FORMAT is json, it logs events like ExecD.Execute.
+func (w Watcher) sync(binds libcnb.Bindings) error {
+	execd := *w.ExecD
+	execd.Bindings = binds
+	execd.Logger = w.Logger
+	return execd.logEvents(w.syncCertDir)
+}
+
+// syncCertDir re-syncs the truststore. If the certificates are verified but cannot be loaded, e.g. because a rotated
+// file is not signed, all links are removed rather than keeping certificates that were not verified again.
+func (w Watcher) syncCertDir(execd *ExecD) error {
+	ts, err := execd.truststore(false)
+	if err != nil {
+		if integrity, pinned, ierr := execd.integrity(); ierr != nil || integrity.Enabled() || pinned.Enabled() {
+			if err := execd.GenerateHashLinks(w.CertDir, nil); err != nil {
+				return fmt.Errorf("failed to remove CA certficate symlinks\n%w", err)
+			}
+			execd.Logger.Infof("Removed all additional CA certificates from system truststore")
+		}
+		return err
+	}
+	// SSL_CERT_DIR cannot change while the application is running, overridden embedded certificates stay trusted
+	if _, err := execd.reconcile(ts, execd.processType()); err != nil {
+		return err
+	}
+	for _, c := range ts.Certificates() {
+		execd.emitAdded(c)
+	}
+
+	splitDir, err := truststore.MkdirTemp(w.CertDir, "..split-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir\n%w", err)
+	}
+	splitPaths, err := ts.WriteFiles(splitDir)
+	if err != nil {
+		return err
+	}
+
+	if err := execd.GenerateHashLinks(w.CertDir, splitPaths); err != nil {
+		return fmt.Errorf("failed to update CA certficate symlinks\n%w", err)
+	}
+
+	// Remove certificates split by ExecD or previous syncs that are no longer linked
+	entries, err := os.ReadDir(w.CertDir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory %q\n%w", w.CertDir, err)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(w.CertDir, entry.Name())
+		if (strings.HasPrefix(entry.Name(), "..split-") && path != splitDir) || entry.Type().IsRegular() {
+			if err := os.RemoveAll(path); err != nil {
+				return fmt.Errorf("failed to remove %q\n%w", path, err)
+			}
+		}
+	}
+
+	execd.Logger.Infof("Updated system truststore with %d additional CA certificate(s)", len(splitPaths))
+	return nil
+}
+
+// dirs returns the directories to watch: the binding roots and the directories of all ca-certificates bindings.
+func (w Watcher) dirs(binds libcnb.Bindings) []string {
+	dirs := append([]string{}, w.BindingRoots...)
+	for _, bind := range bindings.Resolve(binds, bindings.OfType(BindingType)) {
+		if bind.Path != "" {
+			dirs = append(dirs, bind.Path)
+		}
+	}
+	return dirs
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const watchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_CLOSE_WRITE |
+	unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+// StartWatcherProcess starts the running executable with the CommandWatch command for certDir in a new session so
+// that it keeps running after the exec.d helper has exited.
+func StartWatcherProcess(certDir string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("unable to determine executable\n%w", err)
+	}
+
+	cmd := exec.Command(exe, CommandWatch, certDir)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("unable to start %s\n%w", exe, err)
+	}
+	return cmd.Process.Release()
+}
+
+// watchDirs watches the given directories with inotify. A value is sent on the returned channel for every event until
+// the returned close function is called. Directories that do not exist are ignored.
+func watchDirs(dirs []string) (<-chan struct{}, func() error, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to initialize inotify\n%w", err)
+	}
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	for _, dir := range dirs {
+		if _, err := unix.InotifyAddWatch(fd, dir, watchMask); err != nil && err != unix.ENOENT {
+			f.Close()
+			return nil, nil, fmt.Errorf("unable to watch %q\n%w", dir, err)
+		}
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.PathMax))
+		for {
+			if _, err := f.Read(buf); err != nil {
+				return
+			}
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return changes, f.Close, nil
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts
+
+import "errors"
+
+var errWatchUnsupported = errors.New("watching ca-certificates bindings is only supported on linux")
+
+// StartWatcherProcess is only supported on linux.
+func StartWatcherProcess(string) error {
+	return errWatchUnsupported
+}
+
+func watchDirs([]string) (<-chan struct{}, func() error, error) {
+	return nil, nil, errWatchUnsupported
+}
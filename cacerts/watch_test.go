@@ -0,0 +1,230 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacerts_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buildpacks/libcnb"
+	. "github.com/onsi/gomega"
+	"github.com/paketo-buildpacks/libpak/bard"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/cacerts"
+)
+
+func testWatcher(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect       = NewWithT(t).Expect
+		Eventually   = NewWithT(t).Eventually
+		Consistently = NewWithT(t).Consistently
+
+		bindingRoot string
+		certDir     string
+		watcher     cacerts.Watcher
+	)
+
+	copyCert := func(name string, dest string) {
+		raw, err := os.ReadFile(filepath.Join("testdata", name))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(dest, raw, 0644)).To(Succeed())
+	}
+
+	links := func() []string {
+		entries, err := os.ReadDir(certDir)
+		Expect(err).NotTo(HaveOccurred())
+		var names []string
+		for _, e := range entries {
+			if e.Type()&os.ModeSymlink != 0 {
+				names = append(names, e.Name())
+			}
+		}
+		return names
+	}
+
+	it.Before(func() {
+		bindingRoot = t.TempDir()
+		certDir = t.TempDir()
+
+		Expect(os.MkdirAll(filepath.Join(bindingRoot, "certs"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(bindingRoot, "certs", "type"), []byte("ca-certificates"), 0644)).To(Succeed())
+		copyCert("SecureTrust_CA.pem", filepath.Join(bindingRoot, "certs", "ca.pem"))
+
+		execd := cacerts.NewExecD(nil)
+		execd.GetEnv = func(string) string { return "" }
+		watcher = cacerts.Watcher{
+			CertDir: certDir,
+			ExecD:   execd,
+			LoadBindings: func() (libcnb.Bindings, error) {
+				b, err := libcnb.NewBindingFromPath(filepath.Join(bindingRoot, "certs"))
+				return libcnb.Bindings{b}, err
+			},
+			BindingRoots: []string{bindingRoot},
+			Debounce:     10 * time.Millisecond,
+		}
+	})
+
+	context("Sync", func() {
+		it("adds links for new certificates and removes stale links", func() {
+			Expect(watcher.Sync()).To(Succeed())
+			Expect(links()).To(Equal([]string{"f39fc864.0"}))
+
+			Expect(os.Remove(filepath.Join(bindingRoot, "certs", "ca.pem"))).To(Succeed())
+			copyCert("Go_Daddy_Class_2_CA.pem", filepath.Join(bindingRoot, "certs", "other.pem"))
+
+			Expect(watcher.Sync()).To(Succeed())
+			Expect(links()).To(Equal([]string{"f081611a.0"}))
+		})
+
+		it("links copies of verified certificates and removes all links if a rotated certificate is not allowed", func() {
+			watcher.ExecD.GetEnv = func(k string) string {
+				if k == "BPL_CA_CERTS_ALLOWED_FINGERPRINTS" {
+					return "f1c1b50ae5a20dd8030ec9f6bc24823dd367b5255759b4e71b61fce9f7375d73"
+				}
+				return ""
+			}
+
+			Expect(watcher.Sync()).To(Succeed())
+			Expect(links()).To(Equal([]string{"f39fc864.0"}))
+			target, err := filepath.EvalSymlinks(filepath.Join(certDir, "f39fc864.0"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(target).NotTo(HavePrefix(bindingRoot))
+
+			copyCert("Go_Daddy_Class_2_CA.pem", filepath.Join(bindingRoot, "certs", "ca.pem"))
+
+			Expect(watcher.Sync()).To(MatchError(ContainSubstring("neither signed by a trusted key nor allowed by its fingerprint")))
+			Expect(links()).To(BeEmpty())
+		})
+
+		it("removes certificates split by previous syncs", func() {
+			copyCert("multiple-certs.pem", filepath.Join(bindingRoot, "certs", "bundle.pem"))
+			Expect(watcher.Sync()).To(Succeed())
+			Expect(watcher.Sync()).To(Succeed())
+
+			splitDirs, err := filepath.Glob(filepath.Join(certDir, "..split-*"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(splitDirs).To(HaveLen(1))
+			for _, l := range links() {
+				Expect(filepath.Join(certDir, l)).To(BeAnExistingFile())
+			}
+		})
+	})
+
+	context("BPL_CA_CERTS_LOG_FORMAT is json", func() {
+		it("logs an event for each certificate and a summary", func() {
+			buf := &bytes.Buffer{}
+			watcher.Logger = bard.NewLogger(buf)
+			watcher.ExecD.GetEnv = func(k string) string {
+				if k == "BPL_CA_CERTS_LOG_FORMAT" {
+					return "json"
+				}
+				return ""
+			}
+
+			Expect(watcher.Sync()).To(Succeed())
+
+			var events []map[string]interface{}
+			dec := json.NewDecoder(buf)
+			for dec.More() {
+				var e map[string]interface{}
+				Expect(dec.Decode(&e)).To(Succeed())
+				events = append(events, e)
+			}
+			Expect(events).To(HaveLen(2))
+			Expect(events[0]).To(HaveKeyWithValue("event", "added"))
+			Expect(events[0]).To(HaveKeyWithValue("binding", "certs"))
+			Expect(events[1]).To(Equal(map[string]interface{}{
+				"event": "summary", "added": 1.0, "skipped": 0.0, "rejected": 0.0, "expiring": 0.0,
+			}))
+		})
+	})
+
+	context("Run", func() {
+		it.Before(func() {
+			if runtime.GOOS != "linux" {
+				t.Skip("watching is only supported on linux")
+			}
+		})
+
+		it("re-syncs when a binding changes", func() {
+			stop := make(chan struct{})
+			done := make(chan error)
+			go func() { done <- watcher.Run(stop) }()
+
+			Eventually(func() []string {
+				copyCert("Go_Daddy_Class_2_CA.pem", filepath.Join(bindingRoot, "certs", "other.pem"))
+				return links()
+			}, 5*time.Second, 50*time.Millisecond).Should(ContainElement("f081611a.0"))
+
+			close(stop)
+			Eventually(done, 5*time.Second).Should(Receive(BeNil()))
+		})
+
+		it("exits when no process uses the certificate directory", func() {
+			var used atomic.Bool
+			used.Store(true)
+			watcher.CheckInterval = 10 * time.Millisecond
+			watcher.CertDirsInUse = func() (map[string]bool, error) {
+				return map[string]bool{certDir: used.Load()}, nil
+			}
+
+			done := make(chan error)
+			go func() { done <- watcher.Run(make(chan struct{})) }()
+			Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+
+			used.Store(false)
+			Eventually(done, 5*time.Second).Should(Receive(BeNil()))
+		})
+
+		it("exits when the certificate directory is removed", func() {
+			watcher.CheckInterval = 10 * time.Millisecond
+			watcher.CertDirsInUse = func() (map[string]bool, error) {
+				return map[string]bool{certDir: true}, nil
+			}
+
+			done := make(chan error)
+			go func() { done <- watcher.Run(make(chan struct{})) }()
+			Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+
+			Expect(os.RemoveAll(certDir)).To(Succeed())
+			Eventually(done, 5*time.Second).Should(Receive(BeNil()))
+		})
+
+		it("keeps running if the directories in use cannot be determined", func() {
+			watcher.CheckInterval = 10 * time.Millisecond
+			watcher.CertDirsInUse = func() (map[string]bool, error) {
+				return nil, fmt.Errorf("unsupported")
+			}
+
+			stop := make(chan struct{})
+			done := make(chan error)
+			go func() { done <- watcher.Run(stop) }()
+			Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+
+			close(stop)
+			Eventually(done, 5*time.Second).Should(Receive(BeNil()))
+		})
+	})
+}
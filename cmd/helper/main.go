@@ -17,6 +17,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 
@@ -29,6 +30,10 @@ import (
 
 func main() {
 	sherpa.Execute(func() error {
+		if len(os.Args) > 1 {
+			return command(os.Args[1], os.Args[2:])
+		}
+
 		bindings, err := libcnb.NewBindingsFromEnvironment()
 		if err != nil {
 			return fmt.Errorf("unable to read bindings from environment\n%w", err)
@@ -41,3 +46,45 @@ func main() {
 		})
 	})
 }
+
+// command runs one of the helper commands that are not part of the exec.d protocol.
+func command(name string, args []string) error {
+	switch name {
+	case cacerts.CommandWatch:
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s %s <cert-dir>", cacerts.ExecutableCACertsHelper, cacerts.CommandWatch)
+		}
+		w := cacerts.NewWatcher(args[0])
+		w.Logger = bard.NewLogger(os.Stdout)
+		return w.Run(make(chan struct{}))
+	case cacerts.CommandInspect:
+		flags := flag.NewFlagSet(cacerts.CommandInspect, flag.ContinueOnError)
+		format := flags.String("format", "text", "output format, one of [text, json]")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		return inspector().Write(os.Stdout, *format)
+	case cacerts.CommandVerify:
+		flags := flag.NewFlagSet(cacerts.CommandVerify, flag.ContinueOnError)
+		hostname := flags.String("hostname", "", "hostname the leaf certificate must be valid for")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return fmt.Errorf("usage: %s %s [--hostname <hostname>] <chain.pem>", cacerts.ExecutableCACertsHelper, cacerts.CommandVerify)
+		}
+		chain, err := os.ReadFile(flags.Arg(0))
+		if err != nil {
+			return fmt.Errorf("unable to read chain\n%w", err)
+		}
+		return cacerts.NewVerifier(inspector()).Write(os.Stdout, chain, *hostname)
+	default:
+		return fmt.Errorf("unsupported command %s", name)
+	}
+}
+
+func inspector() cacerts.Inspector {
+	// Bindings are only used to determine the source of certificates, so continue without them on error
+	bindings, _ := libcnb.NewBindingsForLaunch()
+	return cacerts.Inspector{ExecD: cacerts.NewExecD(bindings)}
+}
@@ -0,0 +1,260 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package truststore
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/buildpacks/libcnb"
+
+	"github.com/paketo-buildpacks/libpak/bard"
+	"github.com/paketo-buildpacks/libpak/bindings"
+)
+
+const (
+	BindingType = "ca-certificates" // BindingType is used to resolve bindings containing CA certificates
+
+	// BindingKeyScope is the key of a ca-certificates binding containing the scope of its certificates, one of
+	// ScopeBuild, ScopeLaunch or ScopeBoth. It is never loaded as a certificate.
+	BindingKeyScope = "scope"
+
+	// BindingKeyEmbed is the key of a ca-certificates binding containing "true" or "false" to override whether its
+	// certificates are embedded into the application image. It is never loaded as a certificate.
+	BindingKeyEmbed = "embed"
+)
+
+// Keys of the kubernetes.io/tls secret layout, as also used by cert-manager.
+const (
+	// TLSKeyCACert contains the CA certificate(s) of the issuer.
+	TLSKeyCACert = "ca.crt"
+
+	// TLSKeyCert contains the certificate chain starting with the leaf certificate.
+	TLSKeyCert = "tls.crt"
+
+	// TLSKeyPrivateKey contains the private key of the leaf certificate.
+	TLSKeyPrivateKey = "tls.key"
+)
+
+// DefaultBindingKeyPatterns are the patterns matched by a BindingKeyFilter without include patterns, in addition to
+// keys without an extension.
+var DefaultBindingKeyPatterns = []string{"*.pem", "*.crt", "*.cer", "*.der", "*.p7b"}
+
+// BindingKeyFilter selects the keys of a ca-certificates binding that are loaded as certificates.
+type BindingKeyFilter struct {
+	// Include are the glob patterns of keys to load. If empty, keys matching DefaultBindingKeyPatterns and keys
+	// without an extension are loaded.
+	Include []string
+
+	// Exclude are the glob patterns of keys that are never loaded.
+	Exclude []string
+}
+
+// ParseBindingKeyFilter parses a comma or whitespace separated list of glob patterns. Patterns prefixed with "!"
+// exclude matching keys, all other patterns include them.
+func ParseBindingKeyFilter(s string) (BindingKeyFilter, error) {
+	var f BindingKeyFilter
+	for _, p := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		exclude := strings.HasPrefix(p, "!")
+		p = strings.TrimPrefix(p, "!")
+		if _, err := path.Match(p, ""); err != nil || p == "" {
+			return BindingKeyFilter{}, fmt.Errorf("invalid binding key pattern %q", p)
+		}
+		if exclude {
+			f.Exclude = append(f.Exclude, p)
+		} else {
+			f.Include = append(f.Include, p)
+		}
+	}
+	return f, nil
+}
+
+// Matches returns true if the key should be loaded.
+func (f BindingKeyFilter) Matches(key string) bool {
+	if matchesAny(f.Exclude, key) {
+		return false
+	}
+	if len(f.Include) == 0 {
+		return filepath.Ext(key) == "" || matchesAny(DefaultBindingKeyPatterns, key)
+	}
+	return matchesAny(f.Include, key)
+}
+
+func matchesAny(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// BindingFile is a file provided by a ca-certificates binding.
+type BindingFile struct {
+	// Path is the path of the file.
+	Path string
+
+	// Binding is the name of the binding.
+	Binding string
+
+	// Key is the key of the file in the binding, slash separated for files in subdirectories.
+	Key string
+
+	// CAOnly indicates that only CA certificates from the file should be trusted.
+	CAOnly bool
+
+	// Scope is the value of the BindingKeyScope key of the binding, empty if it does not have one.
+	Scope string
+
+	// Embed is the value of the BindingKeyEmbed key of the binding, empty if it does not have one.
+	Embed string
+}
+
+// BindingFiles returns the files of all bindings of type "ca-certificates" whose keys match the filter
+// sorted by path.
+//
+// Hidden keys and the BindingKeyScope and BindingKeyEmbed keys are skipped. If the binding is a Kubernetes volume,
+// files in its subdirectories, as created by projected volumes, are included with their relative path as key. Keys
+// resolving to the same file, e.g. through the "..data" symlink of a Kubernetes volume, are only returned once. The
+// returned paths are the unresolved key paths so that they remain valid when the volume is updated.
+//
+// Bindings with a "tls.key" key are treated as kubernetes.io/tls secrets: "tls.key" is ignored, "ca.crt" is trusted
+// and only CA certificates are trusted from the chain in "tls.crt".
+func BindingFiles(binds libcnb.Bindings, filter BindingKeyFilter, logger bard.Logger) []BindingFile {
+	var files []BindingFile
+	for _, bind := range bindings.Resolve(binds, bindings.OfType(BindingType)) {
+		_, tlsLayout := bind.Secret[TLSKeyPrivateKey]
+		scope := strings.TrimSpace(bind.Secret[BindingKeyScope])
+		embed := strings.TrimSpace(bind.Secret[BindingKeyEmbed])
+
+		keys := map[string]string{}
+		for k := range bind.Secret {
+			if path, ok := bind.SecretFilePath(k); ok {
+				keys[k] = path
+			}
+		}
+		if isKubernetesVolume(bind.Path) {
+			for _, k := range nestedBindingKeys(bind.Path, "", map[string]bool{}) {
+				keys[k] = filepath.Join(bind.Path, filepath.FromSlash(k))
+			}
+		}
+
+		for k, file := range keys {
+			f := BindingFile{Path: file, Binding: bind.Name, Key: k, Scope: scope, Embed: embed}
+			switch {
+			case isHidden(k):
+				logger.Debugf("Skipping hidden key %q of binding %q", k, bind.Name)
+				continue
+			case k == BindingKeyScope || k == BindingKeyEmbed:
+				continue
+			case tlsLayout && k == TLSKeyPrivateKey:
+				logger.Bodyf("Ignoring private key %q of TLS secret binding %q", k, bind.Name)
+				continue
+			case !filter.Matches(path.Base(k)):
+				logger.Debugf("Skipping key %q of binding %q not matching binding key patterns", k, bind.Name)
+				continue
+			case tlsLayout && k == TLSKeyCert:
+				logger.Bodyf("Using CA certificates from chain %q of TLS secret binding %q", k, bind.Name)
+				f.CAOnly = true
+			case tlsLayout && k == TLSKeyCACert:
+				logger.Bodyf("Using CA certificate %q of TLS secret binding %q", k, bind.Name)
+			default:
+				logger.Debugf("Using certificate %q of binding %q", k, bind.Name)
+			}
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+
+	resolved := map[string]string{}
+	unique := files[:0]
+	for _, f := range files {
+		target, err := filepath.EvalSymlinks(f.Path)
+		if err != nil {
+			target = f.Path
+		}
+		if first, ok := resolved[target]; ok {
+			logger.Debugf("Skipping key %q of binding %q resolving to the same file as %q", f.Key, f.Binding, first)
+			continue
+		}
+		resolved[target] = f.Path
+		unique = append(unique, f)
+	}
+	return unique
+}
+
+// isKubernetesVolume returns true if dir contains the "..data" symlink created by the Kubernetes atomic writer.
+func isKubernetesVolume(dir string) bool {
+	if dir == "" {
+		return false
+	}
+	_, err := os.Lstat(filepath.Join(dir, "..data"))
+	return err == nil
+}
+
+// nestedBindingKeys returns the slash separated paths, relative to root, of all files in non-hidden subdirectories
+// of dir. The "metadata" and "secret" directories of the legacy binding layout are skipped at the root.
+func nestedBindingKeys(root string, dir string, visited map[string]bool) []string {
+	entries, err := os.ReadDir(filepath.Join(root, dir))
+	if err != nil {
+		return nil
+	}
+
+	var keys []string
+	for _, entry := range entries {
+		key := path.Join(dir, entry.Name())
+		if isHidden(entry.Name()) || (dir == "" && (entry.Name() == "metadata" || entry.Name() == "secret")) {
+			continue
+		}
+
+		file := filepath.Join(root, filepath.FromSlash(key))
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() {
+			if dir != "" {
+				keys = append(keys, key)
+			}
+			continue
+		}
+
+		target, err := filepath.EvalSymlinks(file)
+		if err != nil || visited[target] {
+			continue
+		}
+		visited[target] = true
+		keys = append(keys, nestedBindingKeys(root, key, visited)...)
+	}
+	return keys
+}
+
+func isHidden(key string) bool {
+	for _, segment := range strings.Split(key, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package truststore_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/buildpacks/libcnb"
+	. "github.com/onsi/gomega"
+	"github.com/paketo-buildpacks/libpak/bard"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+func testBindings(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+	)
+
+	context("BindingKeyFilter", func() {
+		it("matches certificate extensions and keys without extension by default", func() {
+			f, err := truststore.ParseBindingKeyFilter("")
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, k := range []string{"ca.pem", "ca.crt", "ca.cer", "ca.der", "ca.p7b", "ca"} {
+				Expect(f.Matches(k)).To(BeTrue(), k)
+			}
+			for _, k := range []string{"README.md", "metadata.json", "tls.key"} {
+				Expect(f.Matches(k)).To(BeFalse(), k)
+			}
+		})
+
+		it("replaces the defaults with include patterns", func() {
+			f, err := truststore.ParseBindingKeyFilter("*.txt, corp-*")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(f).To(Equal(truststore.BindingKeyFilter{Include: []string{"*.txt", "corp-*"}}))
+
+			Expect(f.Matches("ca.txt")).To(BeTrue())
+			Expect(f.Matches("corp-root")).To(BeTrue())
+			Expect(f.Matches("ca.pem")).To(BeFalse())
+		})
+
+		it("applies exclude patterns to the defaults", func() {
+			f, err := truststore.ParseBindingKeyFilter("!legacy-* !*.der")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(f).To(Equal(truststore.BindingKeyFilter{Exclude: []string{"legacy-*", "*.der"}}))
+
+			Expect(f.Matches("ca.pem")).To(BeTrue())
+			Expect(f.Matches("legacy-ca.pem")).To(BeFalse())
+			Expect(f.Matches("ca.der")).To(BeFalse())
+		})
+
+		it("returns an error for invalid patterns", func() {
+			_, err := truststore.ParseBindingKeyFilter("[")
+			Expect(err).To(MatchError(`invalid binding key pattern "["`))
+
+			_, err = truststore.ParseBindingKeyFilter("!")
+			Expect(err).To(MatchError(`invalid binding key pattern ""`))
+		})
+	})
+	context("BindingFiles", func() {
+		it("records the scope of the binding and skips the scope key", func() {
+			binds := libcnb.Bindings{
+				{
+					Name:   "some-binding",
+					Type:   truststore.BindingType,
+					Path:   "some-path",
+					Secret: map[string]string{"ca.pem": "", truststore.BindingKeyScope: " build\n"},
+				},
+			}
+
+			Expect(truststore.BindingFiles(binds, truststore.BindingKeyFilter{}, bard.Logger{})).To(Equal([]truststore.BindingFile{
+				{Path: filepath.Join("some-path", "ca.pem"), Binding: "some-binding", Key: "ca.pem", Scope: truststore.ScopeBuild},
+			}))
+		})
+	})
+}
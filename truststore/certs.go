@@ -0,0 +1,314 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package truststore
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// FileMode is the mode of certificate files and bundles written by this package, readable by the CNB group and
+	// any other user the application runs as.
+	FileMode os.FileMode = 0644
+
+	// DirMode is the mode of directories containing certificates and hash links.
+	DirMode os.FileMode = 0755
+)
+
+// hashLinkPattern matches the names of the links created by SyncHashLinks.
+var hashLinkPattern = regexp.MustCompile(`^[0-9a-f]{8}\.[0-9]+$`)
+
+// SyncHashLinks makes the hash links in the given directory point to exactly the given certificate paths, like
+// openssl's c_rehash. Each file must contain exactly one PEM encoded certificate. The links are named HHHHHHHH.D where
+// HHHHHHHH is the 8 character hexadecimal representation of the SubjectNameHash and D is '0' unless there is a hash
+// conflict, in which case conflicts are numbered in the order of the sorted certificate paths. These links are used
+// by openssl to look up a CA by subject name.
+//
+// Links that already point at the right certificate are kept, missing or outdated links are created in a staging
+// directory and renamed into place, and links for certificates that are no longer present are removed afterwards. The
+// directory therefore always contains a complete set of valid links and calling SyncHashLinks again with the same
+// paths is a no-op. Files in the directory that are not hash links are left untouched.
+func SyncHashLinks(dir string, certPaths []string) error {
+	links, err := hashLinks(certPaths)
+	if err != nil {
+		return err
+	}
+
+	staging, err := os.MkdirTemp(dir, "..rehash-")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory in %q\n%w", dir, err)
+	}
+	defer os.RemoveAll(staging)
+
+	var names []string
+	for name := range links {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		link := filepath.Join(dir, name)
+		if target, err := os.Readlink(link); err == nil && target == links[name] {
+			continue
+		}
+		if err := os.Symlink(links[name], filepath.Join(staging, name)); err != nil {
+			return fmt.Errorf("failed to create link %q\n%w", name, err)
+		}
+		if err := os.Rename(filepath.Join(staging, name), link); err != nil {
+			return fmt.Errorf("failed to move link %q into place\n%w", name, err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory %q\n%w", dir, err)
+	}
+	for _, entry := range entries {
+		if _, ok := links[entry.Name()]; ok || !hashLinkPattern.MatchString(entry.Name()) || entry.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to remove stale link %q\n%w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// hashLinks returns the targets of the hash links for the given certificate paths keyed by link name.
+func hashLinks(certPaths []string) (map[string]string, error) {
+	sorted := append([]string{}, certPaths...)
+	sort.Strings(sorted)
+
+	hashes := map[uint32][]string{}
+	for _, path := range sorted {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file at path %q\n%w", path, err)
+		}
+		cert, err := decodeOneCert(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode certificate from file at path %q\n%w", path, err)
+		}
+		hash, err := SubjectNameHash(cert)
+		if err != nil {
+			return nil, fmt.Errorf("failed compute subject name hash for cert at path %q\n%w", path, err)
+		}
+		hashes[hash] = append(hashes[hash], path)
+	}
+
+	links := map[string]string{}
+	for hash, paths := range hashes {
+		for i, path := range paths {
+			links[fmt.Sprintf("%08x.%d", hash, i)] = path
+		}
+	}
+	return links, nil
+}
+
+// IsHashLink returns true if name is the name of a hash link as created by SyncHashLinks.
+func IsHashLink(name string) bool {
+	return hashLinkPattern.MatchString(name)
+}
+
+// decodeOneCert decodes the certificate in raw, see DecodeCertificates. It is an error if raw does not contain exactly
+// one certificate.
+func decodeOneCert(raw []byte) (*x509.Certificate, error) {
+	certs, err := DecodeCertificates(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch len(certs) {
+	case 0:
+		return nil, errors.New("failed find PEM data")
+	case 1:
+		return certs[0], nil
+	default:
+		return nil, errors.New("found multiple PEM blocks, expected exactly one")
+	}
+}
+
+// Fingerprint returns the hex encoded SHA-256 digest of the DER encoding of the certificate.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// SubjectNameHash is a reimplementation of the X509_subject_name_hash in openssl. It computes the SHA-1
+// of the canonical encoding of the certificate's subject name and returns the 32-bit integer represented by the first
+// four bytes of the hash using little-endian byte order.
+func SubjectNameHash(cert *x509.Certificate) (uint32, error) {
+	name, err := CanonicalName(cert.RawSubject)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute canonical subject name\n%w", err)
+	}
+	hasher := sha1.New()
+	_, err = hasher.Write(name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute sha1sum of canonical subject name\n%w", err)
+	}
+	sum := hasher.Sum(nil)
+	return binary.LittleEndian.Uint32(sum[:4]), nil
+}
+
+// canonicalSET holds a of canonicalATVs. Suffix SET ensures it is marshaled as a set rather than a sequence
+// by asn1.Marshal.
+type canonicalSET []canonicalATV
+
+// canonicalATV is similar to pkix.AttributeTypeAndValue but includes tag to ensure all values are marshaled as
+// ASN.1, UTF8String values
+type canonicalATV struct {
+	Type  asn1.ObjectIdentifier
+	Value string `asn1:"utf8"`
+}
+
+// CanonicalName accepts a DER encoded subject name and returns a "Canonical Encoding" matching that
+// returned by the x509_name_canon function in openssl. All string values are transformed with CanonicalString
+// and UTF8 encoded and the leading SEQ header is removed.
+//
+// For more information see https://stackoverflow.com/questions/34095440/hash-algorithm-for-certificate-crl-directory.
+func CanonicalName(name []byte) ([]byte, error) {
+	var origSeq pkix.RDNSequence
+	_, err := asn1.Unmarshal(name, &origSeq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse subject name\n%w", err)
+	}
+	var result []byte
+	for _, origSet := range origSeq {
+		var canonSet canonicalSET
+		for _, origATV := range origSet {
+			origVal, ok := origATV.Value.(string)
+			if !ok {
+				return nil, errors.New("got unexpected non-string value")
+			}
+			canonSet = append(canonSet, canonicalATV{
+				Type:  origATV.Type,
+				Value: CanonicalString(origVal),
+			})
+		}
+		setBytes, err := asn1.Marshal(canonSet)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal canonical name\n%w", err)
+		}
+		result = append(result, setBytes...)
+	}
+	return result, nil
+}
+
+// CanonicalString transforms the given string. All leading and trailing whitespace is trimmed
+// where whitespace is defined as a space, formfeed, tab, newline, carriage return, or vertical tab
+// character. Any remaining sequence of one or more consecutive whitespace characters in replaced with
+// a single ' '.
+//
+// This is a reimplementation of the asn1_string_canon in openssl
+func CanonicalString(s string) string {
+	s = strings.TrimLeft(s, " \f\t\n\v")
+	s = strings.TrimRight(s, " \f\t\n\v")
+	s = strings.ToLower(s)
+	return string(regexp.MustCompile(`[[:space:]]+`).ReplaceAll([]byte(s), []byte(" ")))
+}
+
+// SplitCerts splits the certificates in the file at path into files in certDir that each contain exactly one PEM
+// encoded certificate and returns their paths. If the file contains a single PEM block of type PEMTypeCertificate its
+// path is returned instead. All formats supported by DecodeCertificates are accepted, certificates in other formats
+// are written as PEMTypeCertificate blocks so that they can be read by OpenSSL and Go.
+func SplitCerts(path string, certDir string) ([]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file at path %q\n%w", path, err)
+	}
+
+	if isCanonicalPEM(raw) {
+		return []string{path}, nil
+	}
+
+	certs, err := DecodeCertificates(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode certificates from file at path %q\n%w", path, err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("failed to decode PEM data")
+	}
+
+	var paths []string
+	for i, cert := range certs {
+		newCertPath, err := writeUniqueFile(certDir, fmt.Sprintf("cert_%d_%s", i, filepath.Base(path)), EncodePEM(cert), FileMode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write extra certficate to file\n%w", err)
+		}
+		paths = append(paths, newCertPath)
+	}
+	return paths, nil
+}
+
+// MkdirTemp creates a new temporary directory in dir like os.MkdirTemp but with DirMode instead of 0700, so that the
+// certificates in it can be read by other users.
+func MkdirTemp(dir string, pattern string) (string, error) {
+	path, err := os.MkdirTemp(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chmod(path, DirMode); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// writeFile writes data to the file at path with FileMode regardless of the umask.
+func writeFile(path string, data []byte) error {
+	if err := os.WriteFile(path, data, FileMode); err != nil {
+		return err
+	}
+	return os.Chmod(path, FileMode)
+}
+
+// writeUniqueFile writes data to a new file named name in dir. If the file already exists, e.g. because bundles from
+// different bindings share a key, a numeric suffix is added to the name. The file has mode perm regardless of the
+// umask.
+func writeUniqueFile(dir string, name string, data []byte, perm os.FileMode) (string, error) {
+	path := filepath.Join(dir, name)
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) {
+			path = filepath.Join(dir, fmt.Sprintf("%s.%d", name, i))
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		if err := f.Chmod(perm); err != nil {
+			f.Close()
+			return "", err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return "", err
+		}
+		return path, f.Close()
+	}
+}
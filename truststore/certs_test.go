@@ -0,0 +1,112 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package truststore_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+func testCerts(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+	)
+
+	context("SyncHashLinks", func() {
+		var (
+			dir   string
+			links func() map[string]string
+		)
+
+		it.Before(func() {
+			dir = t.TempDir()
+			links = func() map[string]string {
+				entries, err := os.ReadDir(dir)
+				Expect(err).NotTo(HaveOccurred())
+				result := map[string]string{}
+				for _, e := range entries {
+					target, err := os.Readlink(filepath.Join(dir, e.Name()))
+					if err == nil {
+						result[e.Name()] = target
+					}
+				}
+				return result
+			}
+		})
+
+		it("is idempotent", func() {
+			paths := []string{
+				filepath.Join("testdata", "SecureTrust_CA.pem"),
+				filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"),
+			}
+			Expect(truststore.SyncHashLinks(dir, paths)).To(Succeed())
+			before, err := os.Lstat(filepath.Join(dir, "f39fc864.0"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(truststore.SyncHashLinks(dir, paths)).To(Succeed())
+			after, err := os.Lstat(filepath.Join(dir, "f39fc864.0"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(os.SameFile(before, after)).To(BeTrue())
+			Expect(links()).To(Equal(map[string]string{
+				"f081611a.0": filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"),
+				"f39fc864.0": filepath.Join("testdata", "SecureTrust_CA.pem"),
+			}))
+		})
+
+		it("removes stale links and renumbers collisions", func() {
+			raw, err := os.ReadFile(filepath.Join("testdata", "SecureTrust_CA.pem"))
+			Expect(err).NotTo(HaveOccurred())
+			duplicate := filepath.Join(t.TempDir(), "SecureTrust_CA_Duplicate.pem")
+			Expect(os.WriteFile(duplicate, raw, 0644)).To(Succeed())
+
+			Expect(truststore.SyncHashLinks(dir, []string{
+				filepath.Join("testdata", "SecureTrust_CA.pem"),
+				duplicate,
+				filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"),
+			})).To(Succeed())
+
+			Expect(truststore.SyncHashLinks(dir, []string{duplicate})).To(Succeed())
+
+			Expect(links()).To(Equal(map[string]string{"f39fc864.0": duplicate}))
+		})
+
+		it("leaves other files untouched", func() {
+			Expect(os.WriteFile(filepath.Join(dir, "cert_0_bundle.pem"), []byte{}, 0644)).To(Succeed())
+			Expect(os.Symlink("some-target", filepath.Join(dir, "other-link"))).To(Succeed())
+
+			Expect(truststore.SyncHashLinks(dir, nil)).To(Succeed())
+
+			Expect(filepath.Join(dir, "cert_0_bundle.pem")).To(BeARegularFile())
+			Expect(links()).To(Equal(map[string]string{"other-link": "some-target"}))
+		})
+
+		it("does not leave a staging directory behind", func() {
+			Expect(truststore.SyncHashLinks(dir, []string{filepath.Join("testdata", "SecureTrust_CA.pem")})).To(Succeed())
+
+			staging, err := filepath.Glob(filepath.Join(dir, "..rehash-*"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(staging).To(BeEmpty())
+		})
+	})
+}
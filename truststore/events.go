@@ -0,0 +1,90 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package truststore
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// EventAdded indicates a certificate that was added to the truststore.
+	EventAdded = "added"
+
+	// EventSkipped indicates a certificate that was not added, e.g. because it is a duplicate.
+	EventSkipped = "skipped"
+
+	// EventRejected indicates a certificate that was refused by the Policy or the Integrity of the truststore.
+	EventRejected = "rejected"
+)
+
+// Event describes the decision about a certificate.
+type Event struct {
+	// Event is one of EventAdded, EventSkipped or EventRejected.
+	Event string `json:"event"`
+
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Subject     string `json:"subject,omitempty"`
+	NotAfter    string `json:"notAfter,omitempty"`
+
+	// Binding and Key are the name and the key of the binding that provided the certificate.
+	Binding string `json:"binding,omitempty"`
+	Key     string `json:"key,omitempty"`
+
+	// Source is the name of the plan entry source or the PEM data for certificates not provided by a binding.
+	Source string `json:"source,omitempty"`
+
+	Path   string `json:"path,omitempty"`
+	Reason string `json:"reason,omitempty"`
+
+	// Warnings are the policy violations of an added certificate, see Certificate.Warnings.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// NewEvent creates an Event about cert from origin. cert may be nil if the event is about an origin whose
+// certificates were not read.
+func NewEvent(event string, cert *x509.Certificate, origin Origin, reason string) Event {
+	e := Event{Event: event, Path: origin.Path, Reason: strings.ReplaceAll(reason, "\n", ": ")}
+	if cert != nil {
+		e.Fingerprint = Fingerprint(cert)
+		e.Subject = cert.Subject.String()
+		e.NotAfter = cert.NotAfter.UTC().Format(time.RFC3339)
+	}
+	if origin.Kind == OriginBinding {
+		e.Binding, e.Key = origin.Name, origin.Key
+	} else {
+		e.Source = origin.Name
+	}
+	return e
+}
+
+// AddedEvent creates the EventAdded Event about c from its first origin, including its policy warnings.
+func (c Certificate) AddedEvent() Event {
+	e := NewEvent(EventAdded, c.Certificate, c.Origins[0], "")
+	for _, v := range c.Warnings {
+		e.Warnings = append(e.Warnings, fmt.Sprintf("%s (%s)", v.Reason, v.Rule))
+	}
+	return e
+}
+
+func (t *Truststore) emit(event string, cert *x509.Certificate, origin Origin, reason string) {
+	if t.Events != nil {
+		t.Events(NewEvent(event, cert, origin, reason))
+	}
+}
@@ -0,0 +1,204 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package truststore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// SignatureExtension is appended to the path of a file to get the path of its detached signature.
+	SignatureExtension = ".sig"
+
+	// SignatureNamespace is the namespace signatures must be created with, i.e.
+	// "ssh-keygen -Y sign -n ca-certificates -f <key> <file>".
+	SignatureNamespace = "ca-certificates"
+)
+
+// Integrity restricts the certificates that can be added to a Truststore to certificates that are either in a file
+// with a detached signature by one of the SigningKeys or whose fingerprint is one of the Fingerprints. If both are
+// empty all certificates can be added.
+type Integrity struct {
+	// Fingerprints are the lower case, hex encoded SHA-256 fingerprints of allowed certificates.
+	Fingerprints []string
+
+	// SigningKeys are the public keys trusted to sign files containing certificates.
+	SigningKeys []ssh.PublicKey
+}
+
+// Enabled returns true if the integrity of certificates is checked.
+func (i Integrity) Enabled() bool {
+	return len(i.Fingerprints) > 0 || len(i.SigningKeys) > 0
+}
+
+// IntegrityError is returned when a certificate is neither signed nor allowed by its fingerprint.
+type IntegrityError struct {
+	Subject     string
+	Fingerprint string
+	Origin      Origin
+}
+
+func (e IntegrityError) Error() string {
+	return fmt.Sprintf("CA certificate %q (SHA-256 fingerprint %s) from %s is neither signed by a trusted key nor allowed by its fingerprint",
+		e.Subject, e.Fingerprint, e.Origin)
+}
+
+// ParseFingerprints parses a comma or whitespace separated list of hex encoded SHA-256 fingerprints. Fingerprints may
+// be upper or lower case and contain colons, as printed by "openssl x509 -fingerprint -sha256".
+func ParseFingerprints(s string) ([]string, error) {
+	var fingerprints []string
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		normalized := strings.ToLower(strings.ReplaceAll(f, ":", ""))
+		if b, err := hex.DecodeString(normalized); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 fingerprint %q", f)
+		}
+		fingerprints = append(fingerprints, normalized)
+	}
+	return fingerprints, nil
+}
+
+// ParseSigningKeys parses public keys in OpenSSH authorized_keys format, one per line.
+func ParseSigningKeys(s string) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	for rest := []byte(s); len(bytes.TrimSpace(rest)) > 0; {
+		key, _, _, r, err := ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key\n%w", err)
+		}
+		keys = append(keys, key)
+		rest = r
+	}
+	return keys, nil
+}
+
+// allows returns true if the fingerprint is one of the allowed fingerprints.
+func (i Integrity) allows(fingerprint string) bool {
+	for _, f := range i.Fingerprints {
+		if f == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// permits returns true if integrity is not enabled or the certificate with the given fingerprint is allowed or in a
+// file signed by one of the signing keys.
+func (i Integrity) permits(fingerprint string, signed bool) bool {
+	return !i.Enabled() || signed || i.allows(fingerprint)
+}
+
+// verifyFile returns true if the file at path, with content raw, has a valid detached signature by one of the
+// signing keys. A missing signature is not an error, an invalid one is.
+func (i Integrity) verifyFile(path string, raw []byte) (bool, error) {
+	if len(i.SigningKeys) == 0 {
+		return false, nil
+	}
+
+	sig, err := os.ReadFile(path + SignatureExtension)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to read signature of %q\n%w", path, err)
+	}
+	if err := VerifySignature(i.SigningKeys, raw, sig); err != nil {
+		return false, fmt.Errorf("invalid signature %q\n%w", path+SignatureExtension, err)
+	}
+	return true, nil
+}
+
+// VerifySignature verifies an armored SSH signature over message, as created by "ssh-keygen -Y sign" with namespace
+// SignatureNamespace, by one of keys.
+func VerifySignature(keys []ssh.PublicKey, message []byte, armored []byte) error {
+	block, _ := pem.Decode(armored)
+	if block == nil || block.Type != "SSH SIGNATURE" {
+		return errors.New("no SSH signature found")
+	}
+	if !bytes.HasPrefix(block.Bytes, []byte("SSHSIG")) {
+		return errors.New("invalid SSH signature magic")
+	}
+
+	var sig struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}
+	if err := ssh.Unmarshal(block.Bytes[len("SSHSIG"):], &sig); err != nil {
+		return fmt.Errorf("failed to decode SSH signature\n%w", err)
+	}
+	if sig.Version != 1 {
+		return fmt.Errorf("unsupported SSH signature version %d", sig.Version)
+	}
+	if sig.Namespace != SignatureNamespace {
+		return fmt.Errorf("signature namespace is %q, expected %q", sig.Namespace, SignatureNamespace)
+	}
+
+	pub, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse public key of signature\n%w", err)
+	}
+	trusted := false
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), pub.Marshal()) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return fmt.Errorf("signed by untrusted key %s", ssh.FingerprintSHA256(pub))
+	}
+
+	var h hash.Hash
+	switch sig.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("unsupported hash algorithm %q", sig.HashAlgorithm)
+	}
+	h.Write(message)
+
+	var signature ssh.Signature
+	if err := ssh.Unmarshal(sig.Signature, &signature); err != nil {
+		return fmt.Errorf("failed to decode signature\n%w", err)
+	}
+	signed := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{sig.Namespace, sig.Reserved, sig.HashAlgorithm, h.Sum(nil)})...)
+	if err := pub.Verify(signed, &signature); err != nil {
+		return fmt.Errorf("signature verification failed\n%w", err)
+	}
+	return nil
+}
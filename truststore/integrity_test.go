@@ -0,0 +1,169 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package truststore_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+func testIntegrity(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		signingKeys, otherKeys []ssh.PublicKey
+		ts                     *truststore.Truststore
+	)
+
+	const fingerprint = "3e6a4b95eb1a6eba3b3a0ad0cd0e90b6b6ab9d4dc4e4b2b5b76f8e7d52e3a4c1"
+
+	it.Before(func() {
+		for name, keys := range map[string]*[]ssh.PublicKey{"signing_key.pub": &signingKeys, "other_key.pub": &otherKeys} {
+			raw, err := os.ReadFile(filepath.Join("testdata", "signed", name))
+			Expect(err).NotTo(HaveOccurred())
+			*keys, err = truststore.ParseSigningKeys(string(raw))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		ts = truststore.New()
+	})
+
+	context("ParseFingerprints", func() {
+		it("normalizes fingerprints", func() {
+			fingerprints, err := truststore.ParseFingerprints("AB:" + fingerprint[2:] + ",\n" + fingerprint)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fingerprints).To(Equal([]string{"ab" + fingerprint[2:], fingerprint}))
+		})
+
+		it("returns an error for invalid fingerprints", func() {
+			_, err := truststore.ParseFingerprints("abcd")
+			Expect(err).To(MatchError(`invalid SHA-256 fingerprint "abcd"`))
+		})
+	})
+
+	context("signing keys", func() {
+		it("adds certificates from files signed by a trusted key", func() {
+			ts.Integrity = truststore.Integrity{SigningKeys: signingKeys}
+			Expect(ts.AddFile(filepath.Join("testdata", "signed", "ca.pem"), truststore.Origin{Kind: truststore.OriginFile})).To(Succeed())
+			Expect(ts.Len()).To(Equal(1))
+		})
+
+		it("does not keep the path of verified files, as they may change after verification", func() {
+			ts.Integrity = truststore.Integrity{SigningKeys: signingKeys}
+			Expect(ts.AddFile(filepath.Join("testdata", "signed", "ca.pem"), truststore.Origin{Kind: truststore.OriginFile})).To(Succeed())
+			Expect(ts.Certificates()[0].Path).To(BeEmpty())
+		})
+
+		it("refuses files signed by other keys", func() {
+			ts.Integrity = truststore.Integrity{SigningKeys: otherKeys}
+			err := ts.AddFile(filepath.Join("testdata", "signed", "ca.pem"), truststore.Origin{Kind: truststore.OriginFile})
+			Expect(err).To(MatchError(ContainSubstring("signed by untrusted key SHA256:")))
+		})
+
+		it("refuses signatures with another namespace", func() {
+			ts.Integrity = truststore.Integrity{SigningKeys: signingKeys}
+			err := ts.AddFile(filepath.Join("testdata", "signed", "wrong-namespace.pem"), truststore.Origin{Kind: truststore.OriginFile})
+			Expect(err).To(MatchError(ContainSubstring(`signature namespace is "file", expected "ca-certificates"`)))
+		})
+
+		it("refuses modified files", func() {
+			dir := t.TempDir()
+			sig, err := os.ReadFile(filepath.Join("testdata", "signed", "ca.pem.sig"))
+			Expect(err).NotTo(HaveOccurred())
+			raw, err := os.ReadFile(filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.WriteFile(filepath.Join(dir, "ca.pem"), raw, 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "ca.pem.sig"), sig, 0644)).To(Succeed())
+
+			ts.Integrity = truststore.Integrity{SigningKeys: signingKeys}
+			err = ts.AddFile(filepath.Join(dir, "ca.pem"), truststore.Origin{Kind: truststore.OriginFile})
+			Expect(err).To(MatchError(ContainSubstring("signature verification failed")))
+		})
+
+		it("refuses unsigned files", func() {
+			ts.Integrity = truststore.Integrity{SigningKeys: signingKeys}
+			err := ts.AddFile(filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"), truststore.Origin{Kind: truststore.OriginFile})
+
+			var integrityErr truststore.IntegrityError
+			Expect(errors.As(err, &integrityErr)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring(`(SHA-256 fingerprint c3846bf24b9e93ca64274c0ec67c1ecc5e024ffcacd2d74019350e81fe546ae4) from "testdata/Go_Daddy_Class_2_CA.pem" is neither signed by a trusted key nor allowed by its fingerprint`)))
+		})
+	})
+
+	context("verified files", func() {
+		it("adds certificates from verified files without checking their integrity", func() {
+			ts.Integrity = truststore.Integrity{SigningKeys: signingKeys}
+			Expect(ts.AddVerifiedFile(filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"), truststore.Origin{Kind: truststore.OriginFile})).To(Succeed())
+			Expect(ts.Len()).To(Equal(1))
+		})
+	})
+
+	context("fingerprints", func() {
+		it("adds allowed certificates and refuses others", func() {
+			ts.Integrity = truststore.Integrity{Fingerprints: []string{"c3846bf24b9e93ca64274c0ec67c1ecc5e024ffcacd2d74019350e81fe546ae4"}}
+
+			Expect(ts.AddFile(filepath.Join("testdata", "multiple-certs.pem"), truststore.Origin{Kind: truststore.OriginFile})).
+				To(MatchError(ContainSubstring(`CA certificate "CN=SecureTrust CA,O=SecureTrust Corporation,C=US"`)))
+			Expect(ts.AddFile(filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"), truststore.Origin{Kind: truststore.OriginFile})).To(Succeed())
+			Expect(ts.Len()).To(Equal(1))
+		})
+	})
+
+	context("audit", func() {
+		it("adds certificates that are not allowed and records the reason", func() {
+			ts.Integrity = truststore.Integrity{SigningKeys: signingKeys}
+			ts.Audit = true
+
+			Expect(ts.AddFile(filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"), truststore.Origin{Kind: truststore.OriginFile})).To(Succeed())
+			Expect(ts.Certificates()).To(HaveLen(1))
+			Expect(ts.Certificates()[0].Rejected).To(Equal("fingerprint is not allowed and file is not signed by a trusted key"))
+		})
+
+		it("adds certificates with invalid signatures", func() {
+			ts.Integrity = truststore.Integrity{SigningKeys: otherKeys}
+			ts.Audit = true
+
+			Expect(ts.AddFile(filepath.Join("testdata", "signed", "ca.pem"), truststore.Origin{Kind: truststore.OriginFile})).To(Succeed())
+			Expect(ts.Certificates()[0].Rejected).To(Equal("fingerprint is not allowed and file is not signed by a trusted key"))
+		})
+	})
+
+	context("pinned", func() {
+		it("refuses certificates that are not pinned even if Integrity allows them", func() {
+			ts.Pinned = truststore.Integrity{SigningKeys: signingKeys}
+			ts.Integrity = truststore.Integrity{Fingerprints: []string{"c3846bf24b9e93ca64274c0ec67c1ecc5e024ffcacd2d74019350e81fe546ae4"}}
+
+			Expect(ts.AddFile(filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"), truststore.Origin{Kind: truststore.OriginFile})).
+				To(MatchError(ContainSubstring("is neither signed by a trusted key nor allowed by its fingerprint")))
+			Expect(ts.Len()).To(Equal(0))
+		})
+
+		it("adds pinned certificates", func() {
+			ts.Pinned = truststore.Integrity{SigningKeys: signingKeys}
+
+			Expect(ts.AddFile(filepath.Join("testdata", "signed", "ca.pem"), truststore.Origin{Kind: truststore.OriginFile})).To(Succeed())
+			Expect(ts.Len()).To(Equal(1))
+		})
+	})
+}
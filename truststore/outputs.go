@@ -0,0 +1,133 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package truststore
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"unicode/utf16"
+)
+
+// DefaultKeystorePassword is the password of the Java keystores shipped with the JDK.
+const DefaultKeystorePassword = "changeit"
+
+// Output writes the certificates of a Truststore to a destination.
+type Output interface {
+	Write(certs []Certificate) error
+}
+
+// HashDir is an Output that creates OpenSSL hash links for the certificates in Dir, as used with SSL_CERT_DIR.
+type HashDir struct {
+	// Dir is the directory containing the hash links.
+	Dir string
+
+	// FilesDir is the directory certificates that are not already in a file of their own are written to. Defaults to
+	// Dir.
+	FilesDir string
+}
+
+func (h HashDir) Write(certs []Certificate) error {
+	filesDir := h.FilesDir
+	if filesDir == "" {
+		filesDir = h.Dir
+	}
+
+	paths, err := WriteFiles(certs, filesDir)
+	if err != nil {
+		return err
+	}
+	if err := SyncHashLinks(h.Dir, paths); err != nil {
+		return fmt.Errorf("failed to generate CA certificate symlinks in %q\n%w", h.Dir, err)
+	}
+	return nil
+}
+
+// PEMBundle is an Output that writes the certificates to a single PEM file, as used with SSL_CERT_FILE.
+type PEMBundle struct {
+	// Path is the path of the bundle.
+	Path string
+}
+
+func (p PEMBundle) Write(certs []Certificate) error {
+	var b bytes.Buffer
+	for _, c := range certs {
+		b.Write(EncodePEM(c.Certificate))
+	}
+	if err := writeFile(p.Path, b.Bytes()); err != nil {
+		return fmt.Errorf("failed to write PEM bundle %q\n%w", p.Path, err)
+	}
+	return nil
+}
+
+// Keystore is an Output that writes the certificates as trusted certificate entries to a Java keystore in JKS format.
+// JKS keystores can be read by all Java versions. Each entry is aliased by the fingerprint of its certificate.
+type Keystore struct {
+	// Path is the path of the keystore.
+	Path string
+
+	// Password is used to protect the integrity of the keystore. Defaults to DefaultKeystorePassword.
+	Password string
+}
+
+func (k Keystore) Write(certs []Certificate) error {
+	password := k.Password
+	if password == "" {
+		password = DefaultKeystorePassword
+	}
+
+	var b bytes.Buffer
+	write := func(v interface{}) { _ = binary.Write(&b, binary.BigEndian, v) }
+	writeUTF := func(s string) {
+		write(uint16(len(s)))
+		b.WriteString(s)
+	}
+
+	write(uint32(0xFEEDFEED)) // magic
+	write(uint32(2))          // version
+	write(uint32(len(certs)))
+	for _, c := range certs {
+		write(uint32(2)) // trusted certificate entry
+		writeUTF(c.Fingerprint)
+		write(c.NotBefore.UnixMilli())
+		writeUTF("X.509")
+		write(uint32(len(c.Raw)))
+		b.Write(c.Raw)
+	}
+
+	// The integrity check is a SHA-1 digest over the UTF-16 password, the string "Mighty Aphrodite" and the keystore
+	h := sha1.New()
+	for _, r := range utf16.Encode([]rune(password)) {
+		h.Write([]byte{byte(r >> 8), byte(r)})
+	}
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(b.Bytes())
+	b.Write(h.Sum(nil))
+
+	if err := writeFile(k.Path, b.Bytes()); err != nil {
+		return fmt.Errorf("failed to write keystore %q\n%w", k.Path, err)
+	}
+	return nil
+}
+
+// EncodePEM returns the PEM encoding of cert.
+func EncodePEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
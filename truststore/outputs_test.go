@@ -0,0 +1,120 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package truststore_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+func testOutputs(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		dir string
+		ts  *truststore.Truststore
+	)
+
+	it.Before(func() {
+		dir = t.TempDir()
+		ts = truststore.New()
+		Expect(ts.AddFile(filepath.Join("testdata", "multiple-certs.pem"), truststore.Origin{Kind: truststore.OriginFile})).To(Succeed())
+	})
+
+	it("writes hash links", func() {
+		Expect(ts.Write(truststore.HashDir{Dir: dir})).To(Succeed())
+
+		Expect(filepath.Join(dir, "f39fc864.0")).To(BeAnExistingFile())
+		Expect(filepath.Join(dir, "f081611a.0")).To(BeAnExistingFile())
+		target, err := os.Readlink(filepath.Join(dir, "f39fc864.0"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target).To(Equal(filepath.Join(dir, "cert_0_multiple-certs.pem")))
+	})
+
+	it("writes a PEM bundle", func() {
+		path := filepath.Join(dir, "bundle.pem")
+		Expect(ts.Write(truststore.PEMBundle{Path: path})).To(Succeed())
+
+		raw, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		certs, err := truststore.DecodeCertificates(raw)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(certs).To(HaveLen(2))
+		Expect(certs[0].Subject.CommonName).To(Equal("SecureTrust CA"))
+
+		info, err := os.Stat(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(truststore.FileMode))
+	})
+
+	it("writes a JKS keystore", func() {
+		path := filepath.Join(dir, "cacerts")
+		Expect(ts.Write(truststore.Keystore{Path: path})).To(Succeed())
+
+		raw, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		data, digest := raw[:len(raw)-sha1.Size], raw[len(raw)-sha1.Size:]
+
+		h := sha1.New()
+		for _, c := range truststore.DefaultKeystorePassword {
+			h.Write([]byte{0, byte(c)})
+		}
+		h.Write([]byte("Mighty Aphrodite"))
+		h.Write(data)
+		Expect(h.Sum(nil)).To(Equal(digest))
+
+		r := bytes.NewReader(data)
+		readUint32 := func() uint32 {
+			var v uint32
+			Expect(binary.Read(r, binary.BigEndian, &v)).To(Succeed())
+			return v
+		}
+		readUTF := func() string {
+			var n uint16
+			Expect(binary.Read(r, binary.BigEndian, &n)).To(Succeed())
+			b := make([]byte, n)
+			_, err := r.Read(b)
+			Expect(err).NotTo(HaveOccurred())
+			return string(b)
+		}
+
+		Expect(readUint32()).To(Equal(uint32(0xFEEDFEED)))
+		Expect(readUint32()).To(Equal(uint32(2)))
+		Expect(readUint32()).To(Equal(uint32(2)))
+		for _, c := range ts.Certificates() {
+			Expect(readUint32()).To(Equal(uint32(2)))
+			Expect(readUTF()).To(Equal(c.Fingerprint))
+			var date int64
+			Expect(binary.Read(r, binary.BigEndian, &date)).To(Succeed())
+			Expect(readUTF()).To(Equal("X.509"))
+			der := make([]byte, readUint32())
+			_, err := r.Read(der)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(der).To(Equal(c.Raw))
+		}
+		Expect(r.Len()).To(BeZero())
+	})
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package truststore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// Types of PEM blocks containing certificates.
+const (
+	// PEMTypeCertificate is the standard type of PEM blocks containing a certificate.
+	PEMTypeCertificate = "CERTIFICATE"
+
+	// PEMTypeX509Certificate is the legacy type of PEM blocks containing a certificate.
+	PEMTypeX509Certificate = "X509 CERTIFICATE"
+
+	// PEMTypeTrustedCertificate is the type of PEM blocks written by "openssl x509 -trustout", containing a
+	// certificate followed by OpenSSL's auxiliary trust settings.
+	PEMTypeTrustedCertificate = "TRUSTED CERTIFICATE"
+
+	// PEMTypePKCS7 is the type of PEM blocks containing a PKCS #7 certificate bundle, as written by
+	// "openssl crl2pkcs7".
+	PEMTypePKCS7 = "PKCS7"
+)
+
+var (
+	pemBegin      = []byte("-----BEGIN")
+	utf8BOM       = []byte{0xEF, 0xBB, 0xBF}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+	errNotBase64 = errors.New("failed to decode PEM data")
+)
+
+// DecodeCertificates decodes all certificates in raw. raw may contain PEM blocks of type PEMTypeCertificate,
+// PEMTypeX509Certificate, PEMTypeTrustedCertificate or PEMTypePKCS7, with CRLF line endings and UTF-8 byte order
+// marks, be base64 encoded DER or PEM data without armor, or be binary DER encoded certificates or a PKCS #7 bundle.
+// PEM blocks of other types and whitespace are ignored, any other data following the last PEM block is an error.
+func DecodeCertificates(raw []byte) ([]*x509.Certificate, error) {
+	text := bytes.ReplaceAll(raw, utf8BOM, nil)
+	text = bytes.ReplaceAll(text, []byte("\r\n"), []byte("\n"))
+
+	if !bytes.Contains(text, pemBegin) {
+		certs, err := decodeBase64(text)
+		if errors.Is(err, errNotBase64) {
+			if certs, err := parseDER(raw); err == nil {
+				return certs, nil
+			}
+		}
+		return certs, err
+	}
+
+	var certs []*x509.Certificate
+	i := 0
+	block, rest := pem.Decode(text)
+	for ; block != nil; block, rest = pem.Decode(rest) {
+		c, err := blockCertificates(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate in PEM block %d (%q)\n%w", i, block.Type, err)
+		}
+		certs = append(certs, c...)
+		i++
+	}
+
+	if rest = bytes.TrimSpace(rest); len(rest) > 0 {
+		switch {
+		case bytes.Contains(rest, pemBegin):
+			return nil, fmt.Errorf("failed to decode PEM block %d", i)
+		case i > 0:
+			return nil, fmt.Errorf("failed to decode PEM data after PEM block %d", i-1)
+		default:
+			return nil, fmt.Errorf("failed to decode PEM data")
+		}
+	}
+	return certs, nil
+}
+
+// blockCertificates returns the certificates in block, none if block is not of a type containing certificates.
+func blockCertificates(block *pem.Block) ([]*x509.Certificate, error) {
+	switch block.Type {
+	case PEMTypeCertificate, PEMTypeX509Certificate:
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return []*x509.Certificate{cert}, nil
+	case PEMTypeTrustedCertificate:
+		// the certificate is followed by an X509_CERT_AUX sequence with the trust settings
+		var raw asn1.RawValue
+		if _, err := asn1.Unmarshal(block.Bytes, &raw); err != nil {
+			return nil, fmt.Errorf("failed to find certificate before trust settings\n%w", err)
+		}
+		cert, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, err
+		}
+		return []*x509.Certificate{cert}, nil
+	case PEMTypePKCS7:
+		return parsePKCS7(block.Bytes)
+	default:
+		return nil, nil
+	}
+}
+
+// decodeBase64 decodes base64 encoded DER or PEM data without armor. Data that is empty or contains whitespace only
+// has no certificates, data that is not base64 returns errNotBase64.
+func decodeBase64(raw []byte) ([]*x509.Certificate, error) {
+	data := bytes.Join(bytes.Fields(raw), nil)
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	n, err := base64.StdEncoding.Decode(decoded, data)
+	if err != nil {
+		return nil, errNotBase64
+	}
+	decoded = decoded[:n]
+
+	if bytes.Contains(decoded, pemBegin) {
+		return DecodeCertificates(decoded)
+	}
+	certs, err := parseDER(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse base64 encoded certificate\n%w", err)
+	}
+	return certs, nil
+}
+
+// parseDER parses one or more DER encoded certificates or a DER encoded PKCS #7 bundle.
+func parseDER(der []byte) ([]*x509.Certificate, error) {
+	certs, err := x509.ParseCertificates(der)
+	if err == nil {
+		return certs, nil
+	}
+	if certs, err := parsePKCS7(der); err == nil {
+		return certs, nil
+	}
+	return nil, err
+}
+
+// parsePKCS7 returns the certificates of a DER encoded PKCS #7 SignedData bundle, as defined in RFC 2315. Signatures
+// and CRLs are ignored.
+func parsePKCS7(der []byte) ([]*x509.Certificate, error) {
+	var info struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("failed to decode PKCS #7 content info\n%w", err)
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("unsupported PKCS #7 content type %s", info.ContentType)
+	}
+
+	var signedData asn1.RawValue
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signedData); err != nil {
+		return nil, fmt.Errorf("failed to decode PKCS #7 signed data\n%w", err)
+	}
+	for rest := signedData.Bytes; len(rest) > 0; {
+		var field asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &field); err != nil {
+			return nil, fmt.Errorf("failed to decode PKCS #7 signed data\n%w", err)
+		}
+		// certificates [0] IMPLICIT SET OF Certificate
+		if field.Class == asn1.ClassContextSpecific && field.Tag == 0 {
+			return x509.ParseCertificates(field.Bytes)
+		}
+	}
+	return nil, nil
+}
+
+// isCanonicalPEM returns true if raw contains a single PEM block of type PEMTypeCertificate that can be read by both
+// OpenSSL and Go's crypto/x509 without decoding it with DecodeCertificates first.
+func isCanonicalPEM(raw []byte) bool {
+	if bytes.Contains(raw, utf8BOM) {
+		return false
+	}
+	block, rest := pem.Decode(raw)
+	return block != nil && block.Type == PEMTypeCertificate && len(bytes.TrimSpace(rest)) == 0
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package truststore
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// PlanEntryMetadataVersion is the version of the ca-certificates build plan entry metadata schema implemented by
+// PlanEntryMetadata.
+const PlanEntryMetadataVersion = 1
+
+// Scopes in which the certificates of a ca-certificates build plan entry may be trusted.
+const (
+	// ScopeBuild indicates that certificates should only be trusted at build time.
+	ScopeBuild = "build"
+
+	// ScopeLaunch indicates that certificates should only be trusted at launch time.
+	ScopeLaunch = "launch"
+
+	// ScopeBoth indicates that certificates should be trusted at build and launch time.
+	ScopeBoth = "both"
+)
+
+// PlanEntryMetadata is the metadata of a "ca-certificates" build plan entry. Buildpacks that require
+// ca-certificates use it to describe the certificates that should be added to the truststore.
+//
+// Metadata keys that are not part of the schema are ignored and recorded in Unknown so that entries written by
+// newer versions of the schema can still be consumed.
+type PlanEntryMetadata struct {
+	// Version is the schema version the metadata was written for. Defaults to PlanEntryMetadataVersion.
+	Version int
+
+	// Paths are paths to files containing one or more PEM encoded certificates.
+	Paths []string
+
+	// Certificates are PEM encoded certificates provided inline.
+	Certificates []string
+
+	// Embed, if set, overrides $BP_EMBED_CERTS for the certificates of the entry.
+	Embed *bool
+
+	// Scope is one of ScopeBuild, ScopeLaunch or ScopeBoth. If empty the certificates are trusted at build time and
+	// at launch time if they are embedded.
+	Scope string
+
+	// Source is a free form description of where the certificates came from, e.g. the id of the requiring buildpack.
+	Source string
+
+	// Unknown contains the sorted metadata keys that are not part of the schema.
+	Unknown []string
+}
+
+// PlanEntryMetadataError describes an invalid field of ca-certificates build plan entry metadata.
+type PlanEntryMetadataError struct {
+	Field  string
+	Reason string
+}
+
+func (e PlanEntryMetadataError) Error() string {
+	return fmt.Sprintf("invalid ca-certificates build plan entry metadata field %q: %s", e.Field, e.Reason)
+}
+
+// NewPlanEntryMetadata decodes and validates the metadata of a ca-certificates build plan entry.
+func NewPlanEntryMetadata(md map[string]interface{}) (PlanEntryMetadata, error) {
+	m := PlanEntryMetadata{Version: PlanEntryMetadataVersion}
+
+	keys := make([]string, 0, len(md))
+	for key := range md {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		raw := md[key]
+		var err error
+		switch key {
+		case "version":
+			m.Version, err = decodeInt(key, raw)
+		case "paths":
+			m.Paths, err = decodeStrings(key, raw)
+		case "certificates":
+			m.Certificates, err = decodeStrings(key, raw)
+		case "embed":
+			b, ok := raw.(bool)
+			if !ok {
+				err = PlanEntryMetadataError{Field: key, Reason: fmt.Sprintf("expected bool, got %T", raw)}
+			}
+			m.Embed = &b
+		case "scope":
+			m.Scope, err = decodeString(key, raw)
+		case "source":
+			m.Source, err = decodeString(key, raw)
+		default:
+			m.Unknown = append(m.Unknown, key)
+		}
+		if err != nil {
+			return PlanEntryMetadata{}, err
+		}
+	}
+
+	if err := m.Validate(); err != nil {
+		return PlanEntryMetadata{}, err
+	}
+	return m, nil
+}
+
+// Validate returns a PlanEntryMetadataError if the metadata is not valid.
+func (m PlanEntryMetadata) Validate() error {
+	if m.Version < 1 {
+		return PlanEntryMetadataError{Field: "version", Reason: fmt.Sprintf("must be at least 1, got %d", m.Version)}
+	}
+	if len(m.Paths) == 0 && len(m.Certificates) == 0 {
+		return PlanEntryMetadataError{Field: "paths", Reason: `required unless "certificates" is set`}
+	}
+	for i, p := range m.Paths {
+		if strings.TrimSpace(p) == "" {
+			return PlanEntryMetadataError{Field: fmt.Sprintf("paths[%d]", i), Reason: "must not be empty"}
+		}
+	}
+	for i, c := range m.Certificates {
+		if certs, err := DecodeCertificates([]byte(c)); err != nil || len(certs) == 0 {
+			return PlanEntryMetadataError{Field: fmt.Sprintf("certificates[%d]", i), Reason: "does not contain PEM data"}
+		}
+	}
+	if err := ValidateScope(m.Scope); err != nil {
+		return PlanEntryMetadataError{Field: "scope", Reason: err.Error()}
+	}
+	return nil
+}
+
+// ValidateScope returns an error if scope is neither empty nor one of ScopeBuild, ScopeLaunch or ScopeBoth.
+func ValidateScope(scope string) error {
+	switch scope {
+	case "", ScopeBuild, ScopeLaunch, ScopeBoth:
+		return nil
+	}
+	return fmt.Errorf("expected one of [%s, %s, %s], got %q", ScopeBuild, ScopeLaunch, ScopeBoth, scope)
+}
+
+// TrustedAt returns whether certificates with the given scope are trusted at build and at launch time. Certificates
+// without a scope are trusted at build time and at launch time if they are embedded.
+func TrustedAt(scope string, embed bool) (build bool, launch bool) {
+	switch scope {
+	case ScopeBuild:
+		return true, false
+	case ScopeLaunch:
+		return false, true
+	case ScopeBoth:
+		return true, true
+	default:
+		return true, embed
+	}
+}
+
+// AsMap returns the metadata as it should appear in a build plan require. Empty fields are omitted.
+func (m PlanEntryMetadata) AsMap() map[string]interface{} {
+	md := map[string]interface{}{}
+	if m.Version > PlanEntryMetadataVersion {
+		md["version"] = m.Version
+	}
+	if len(m.Paths) > 0 {
+		md["paths"] = m.Paths
+	}
+	if len(m.Certificates) > 0 {
+		md["certificates"] = m.Certificates
+	}
+	if m.Embed != nil {
+		md["embed"] = *m.Embed
+	}
+	if m.Scope != "" {
+		md["scope"] = m.Scope
+	}
+	if m.Source != "" {
+		md["source"] = m.Source
+	}
+	return md
+}
+
+func decodeString(field string, raw interface{}) (string, error) {
+	s, ok := raw.(string)
+	if !ok {
+		return "", PlanEntryMetadataError{Field: field, Reason: fmt.Sprintf("expected string, got %T", raw)}
+	}
+	return s, nil
+}
+
+func decodeStrings(field string, raw interface{}) ([]string, error) {
+	switch v := raw.(type) {
+	case []string:
+		return v, nil
+	case []interface{}:
+		s := make([]string, len(v))
+		for i, item := range v {
+			var err error
+			if s[i], err = decodeString(fmt.Sprintf("%s[%d]", field, i), item); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	default:
+		return nil, PlanEntryMetadataError{Field: field, Reason: fmt.Sprintf("expected array of strings, got %T", raw)}
+	}
+}
+
+func decodeInt(field string, raw interface{}) (int, error) {
+	switch v := raw.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v == math.Trunc(v) {
+			return int(v), nil
+		}
+	}
+	return 0, PlanEntryMetadataError{Field: field, Reason: fmt.Sprintf("expected integer, got %v", raw)}
+}
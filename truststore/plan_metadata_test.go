@@ -0,0 +1,146 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package truststore_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+func testPlanEntryMetadata(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+	)
+
+	context("NewPlanEntryMetadata", func() {
+		it("decodes all fields", func() {
+			raw, err := os.ReadFile(filepath.Join("testdata", "SecureTrust_CA.pem"))
+			Expect(err).NotTo(HaveOccurred())
+
+			md, err := truststore.NewPlanEntryMetadata(map[string]interface{}{
+				"version":      int64(1),
+				"paths":        []interface{}{"some-path"},
+				"certificates": []interface{}{string(raw)},
+				"embed":        true,
+				"scope":        "both",
+				"source":       "some-buildpack",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			embed := true
+			Expect(md).To(Equal(truststore.PlanEntryMetadata{
+				Version:      1,
+				Paths:        []string{"some-path"},
+				Certificates: []string{string(raw)},
+				Embed:        &embed,
+				Scope:        truststore.ScopeBoth,
+				Source:       "some-buildpack",
+			}))
+		})
+
+		it("defaults the version", func() {
+			md, err := truststore.NewPlanEntryMetadata(map[string]interface{}{
+				"paths": []string{"some-path"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(md.Version).To(Equal(truststore.PlanEntryMetadataVersion))
+		})
+
+		it("records unknown keys", func() {
+			md, err := truststore.NewPlanEntryMetadata(map[string]interface{}{
+				"paths":   []string{"some-path"},
+				"zz-key":  "some-value",
+				"aa-key":  1,
+				"version": 2,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(md.Unknown).To(Equal([]string{"aa-key", "zz-key"}))
+		})
+
+		it("reports the first invalid field in key order", func() {
+			for i := 0; i < 10; i++ {
+				_, err := truststore.NewPlanEntryMetadata(map[string]interface{}{
+					"certificates": "some-certificate",
+					"paths":        "some-path",
+					"scope":        1,
+					"source":       2,
+				})
+				Expect(err).To(MatchError(ContainSubstring(`field "certificates"`)))
+			}
+		})
+
+		it("requires paths or certificates", func() {
+			_, err := truststore.NewPlanEntryMetadata(map[string]interface{}{})
+			Expect(err).To(MatchError(truststore.PlanEntryMetadataError{
+				Field:  "paths",
+				Reason: `required unless "certificates" is set`,
+			}))
+		})
+
+		it("points at invalid fields", func() {
+			_, err := truststore.NewPlanEntryMetadata(map[string]interface{}{"paths": "some-path"})
+			Expect(err).To(MatchError(`invalid ca-certificates build plan entry metadata field "paths": expected array of strings, got string`))
+
+			_, err = truststore.NewPlanEntryMetadata(map[string]interface{}{"paths": []interface{}{"some-path", ""}})
+			Expect(err).To(MatchError(ContainSubstring(`field "paths[1]": must not be empty`)))
+
+			_, err = truststore.NewPlanEntryMetadata(map[string]interface{}{"certificates": []interface{}{"not-pem"}})
+			Expect(err).To(MatchError(ContainSubstring(`field "certificates[0]": does not contain PEM data`)))
+
+			_, err = truststore.NewPlanEntryMetadata(map[string]interface{}{"paths": []string{"some-path"}, "embed": "yes"})
+			Expect(err).To(MatchError(ContainSubstring(`field "embed": expected bool, got string`)))
+
+			_, err = truststore.NewPlanEntryMetadata(map[string]interface{}{"paths": []string{"some-path"}, "scope": "runtime"})
+			Expect(err).To(MatchError(ContainSubstring(`field "scope": expected one of [build, launch, both], got "runtime"`)))
+
+			_, err = truststore.NewPlanEntryMetadata(map[string]interface{}{"paths": []string{"some-path"}, "version": 0})
+			Expect(err).To(MatchError(ContainSubstring(`field "version": must be at least 1, got 0`)))
+		})
+	})
+
+	context("AsMap", func() {
+		it("omits empty fields", func() {
+			Expect(truststore.PlanEntryMetadata{
+				Version: truststore.PlanEntryMetadataVersion,
+				Paths:   []string{"some-path"},
+				Source:  "some-source",
+			}.AsMap()).To(Equal(map[string]interface{}{
+				"paths":  []string{"some-path"},
+				"source": "some-source",
+			}))
+		})
+
+		it("round trips", func() {
+			embed := false
+			md := truststore.PlanEntryMetadata{
+				Version: truststore.PlanEntryMetadataVersion,
+				Paths:   []string{"some-path"},
+				Embed:   &embed,
+				Scope:   truststore.ScopeLaunch,
+			}
+			decoded, err := truststore.NewPlanEntryMetadata(md.AsMap())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoded).To(Equal(md))
+		})
+	})
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package truststore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Actions taken for a certificate that violates a PolicyRule.
+const (
+	// PolicyWarn logs a warning and trusts the certificate.
+	PolicyWarn = "warn"
+
+	// PolicyReject fails loading the certificate.
+	PolicyReject = "reject"
+)
+
+// MinRSAKeySize is the minimum size of RSA keys in bits accepted without a violation.
+const MinRSAKeySize = 2048
+
+// PolicyRule checks a single property of a certificate.
+type PolicyRule struct {
+	// Name identifies the rule in messages.
+	Name string
+
+	// Action is PolicyWarn or PolicyReject.
+	Action string
+
+	// Check returns a description of the violation and true if the certificate violates the rule.
+	Check func(cert *x509.Certificate) (string, bool)
+}
+
+// Policy is a named set of rules applied to every certificate added to a Truststore.
+type Policy struct {
+	Name  string
+	Rules []PolicyRule
+}
+
+// Violation is a violation of a PolicyRule.
+type Violation struct {
+	Rule   string
+	Action string
+	Reason string
+}
+
+// Rules checking for weak cryptography.
+var (
+	// RuleRSAKeySize is violated by RSA keys shorter than MinRSAKeySize.
+	RuleRSAKeySize = PolicyRule{Name: "rsa-key-size", Check: func(cert *x509.Certificate) (string, bool) {
+		if key, ok := cert.PublicKey.(*rsa.PublicKey); ok && key.N.BitLen() < MinRSAKeySize {
+			return fmt.Sprintf("RSA key has %d bits, expected at least %d", key.N.BitLen(), MinRSAKeySize), true
+		}
+		return "", false
+	}}
+
+	// RuleDSA is violated by DSA keys and signatures.
+	RuleDSA = PolicyRule{Name: "dsa", Check: func(cert *x509.Certificate) (string, bool) {
+		if cert.PublicKeyAlgorithm == x509.DSA {
+			return "DSA keys are not allowed", true
+		}
+		if cert.SignatureAlgorithm == x509.DSAWithSHA1 || cert.SignatureAlgorithm == x509.DSAWithSHA256 {
+			return fmt.Sprintf("%s signatures are not allowed", cert.SignatureAlgorithm), true
+		}
+		return "", false
+	}}
+
+	// RuleWeakSignature is violated by signatures using MD2, MD5 or SHA-1.
+	RuleWeakSignature = PolicyRule{Name: "weak-signature", Check: func(cert *x509.Certificate) (string, bool) {
+		switch cert.SignatureAlgorithm {
+		case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+			return fmt.Sprintf("signature algorithm %s is weak", cert.SignatureAlgorithm), true
+		}
+		return "", false
+	}}
+
+	// RuleFIPSKey is violated by keys that are not approved by FIPS 186-4, i.e. keys other than RSA and ECDSA keys on
+	// the P-256, P-384 and P-521 curves.
+	RuleFIPSKey = PolicyRule{Name: "fips-key", Check: func(cert *x509.Certificate) (string, bool) {
+		switch key := cert.PublicKey.(type) {
+		case *rsa.PublicKey:
+			return "", false
+		case *ecdsa.PublicKey:
+			switch key.Curve {
+			case elliptic.P256(), elliptic.P384(), elliptic.P521():
+				return "", false
+			}
+			return fmt.Sprintf("ECDSA curve %s is not FIPS approved", key.Curve.Params().Name), true
+		default:
+			return fmt.Sprintf("%s keys are not FIPS approved", cert.PublicKeyAlgorithm), true
+		}
+	}}
+)
+
+// Policy profiles selectable by name.
+var (
+	// DefaultPolicy warns about weak keys and signatures.
+	DefaultPolicy = Policy{Name: "default", Rules: []PolicyRule{
+		withAction(RuleRSAKeySize, PolicyWarn),
+		withAction(RuleDSA, PolicyWarn),
+		withAction(RuleWeakSignature, PolicyWarn),
+	}}
+
+	// StrictPolicy rejects weak keys and signatures.
+	StrictPolicy = Policy{Name: "strict", Rules: []PolicyRule{
+		withAction(RuleRSAKeySize, PolicyReject),
+		withAction(RuleDSA, PolicyReject),
+		withAction(RuleWeakSignature, PolicyReject),
+	}}
+
+	// FIPSPolicy rejects weak keys and signatures and keys that are not FIPS approved.
+	FIPSPolicy = Policy{Name: "fips", Rules: []PolicyRule{
+		withAction(RuleRSAKeySize, PolicyReject),
+		withAction(RuleDSA, PolicyReject),
+		withAction(RuleWeakSignature, PolicyReject),
+		withAction(RuleFIPSKey, PolicyReject),
+	}}
+)
+
+// Policies are the policy profiles keyed by name.
+var Policies = map[string]Policy{
+	DefaultPolicy.Name: DefaultPolicy,
+	StrictPolicy.Name:  StrictPolicy,
+	FIPSPolicy.Name:    FIPSPolicy,
+}
+
+// LookupPolicy returns the policy profile with the given name. An empty name selects DefaultPolicy.
+func LookupPolicy(name string) (Policy, error) {
+	if name == "" {
+		return DefaultPolicy, nil
+	}
+	if p, ok := Policies[name]; ok {
+		return p, nil
+	}
+
+	var names []string
+	for n := range Policies {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return Policy{}, fmt.Errorf("unknown policy %q, expected one of [%s]", name, strings.Join(names, ", "))
+}
+
+// Evaluate returns the violations of the policy by cert.
+func (p Policy) Evaluate(cert *x509.Certificate) []Violation {
+	var violations []Violation
+	for _, r := range p.Rules {
+		if reason, ok := r.Check(cert); ok {
+			violations = append(violations, Violation{Rule: r.Name, Action: r.Action, Reason: reason})
+		}
+	}
+	return violations
+}
+
+// PolicyError is returned when a certificate violates a rule of a policy with action PolicyReject.
+type PolicyError struct {
+	Policy     string
+	Subject    string
+	Origin     Origin
+	Violations []Violation
+}
+
+func (e PolicyError) Error() string {
+	return fmt.Sprintf("CA certificate %q from %s violates the %q policy: %s",
+		e.Subject, e.Origin, e.Policy, reasons(e.Violations))
+}
+
+func reasons(violations []Violation) string {
+	var s []string
+	for _, v := range violations {
+		s = append(s, fmt.Sprintf("%s (%s)", v.Reason, v.Rule))
+	}
+	return strings.Join(s, ", ")
+}
+
+func withAction(r PolicyRule, action string) PolicyRule {
+	r.Action = action
+	return r
+}
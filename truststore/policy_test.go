@@ -0,0 +1,148 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package truststore_test
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildpacks/libcnb"
+	. "github.com/onsi/gomega"
+	"github.com/paketo-buildpacks/libpak/bard"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+func testPolicy(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		load = func(name string) *x509.Certificate {
+			raw, err := os.ReadFile(filepath.Join("testdata", name))
+			Expect(err).NotTo(HaveOccurred())
+			certs, err := truststore.DecodeCertificates(raw)
+			Expect(err).NotTo(HaveOccurred())
+			return certs[0]
+		}
+	)
+
+	context("Evaluate", func() {
+		it("accepts strong certificates", func() {
+			Expect(truststore.FIPSPolicy.Evaluate(load(filepath.Join("tls-secret", "ca.crt")))).To(BeEmpty())
+		})
+
+		it("finds weak RSA keys and signatures", func() {
+			Expect(truststore.DefaultPolicy.Evaluate(load(filepath.Join("policy", "weak-rsa-sha1.pem")))).To(Equal([]truststore.Violation{
+				{Rule: "rsa-key-size", Action: truststore.PolicyWarn, Reason: "RSA key has 1024 bits, expected at least 2048"},
+				{Rule: "weak-signature", Action: truststore.PolicyWarn, Reason: "signature algorithm SHA1-RSA is weak"},
+			}))
+		})
+
+		it("finds DSA keys", func() {
+			Expect(truststore.StrictPolicy.Evaluate(load(filepath.Join("policy", "dsa.pem")))).To(Equal([]truststore.Violation{
+				{Rule: "dsa", Action: truststore.PolicyReject, Reason: "DSA keys are not allowed"},
+			}))
+		})
+
+		it("only rejects keys that are not FIPS approved with the fips policy", func() {
+			cert := load(filepath.Join("policy", "ed25519.pem"))
+			Expect(truststore.StrictPolicy.Evaluate(cert)).To(BeEmpty())
+			Expect(truststore.FIPSPolicy.Evaluate(cert)).To(Equal([]truststore.Violation{
+				{Rule: "fips-key", Action: truststore.PolicyReject, Reason: "Ed25519 keys are not FIPS approved"},
+			}))
+		})
+	})
+
+	context("LookupPolicy", func() {
+		it("defaults to the default policy", func() {
+			p, err := truststore.LookupPolicy("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p.Name).To(Equal("default"))
+		})
+
+		it("returns an error for unknown policies", func() {
+			_, err := truststore.LookupPolicy("lax")
+			Expect(err).To(MatchError(`unknown policy "lax", expected one of [default, fips, strict]`))
+		})
+	})
+
+	context("Truststore", func() {
+		var (
+			binds libcnb.Bindings
+			buf   *bytes.Buffer
+			ts    *truststore.Truststore
+		)
+
+		it.Before(func() {
+			binds = libcnb.Bindings{
+				{
+					Name:   "some-binding",
+					Type:   truststore.BindingType,
+					Path:   filepath.Join("testdata", "policy"),
+					Secret: map[string]string{"weak-rsa-sha1.pem": ""},
+				},
+			}
+			buf = &bytes.Buffer{}
+			ts = truststore.New()
+			ts.Logger = bard.NewLogger(buf)
+		})
+
+		it("warns naming the binding and key", func() {
+			ts.Policy = truststore.DefaultPolicy
+
+			Expect(ts.AddBindings(binds, truststore.BindingKeyFilter{})).To(Succeed())
+			Expect(ts.Len()).To(Equal(1))
+			Expect(buf.String()).To(ContainSubstring(`Warning: CA certificate "CN=Weak RSA SHA1 CA" from key "weak-rsa-sha1.pem" of binding "some-binding" violates the "default" policy: ` +
+				`RSA key has 1024 bits, expected at least 2048 (rsa-key-size), signature algorithm SHA1-RSA is weak (weak-signature)`))
+		})
+
+		it("records the warnings in the added event", func() {
+			ts.Policy = truststore.DefaultPolicy
+
+			Expect(ts.AddBindings(binds, truststore.BindingKeyFilter{})).To(Succeed())
+			Expect(ts.Certificates()[0].AddedEvent().Warnings).To(Equal([]string{
+				"RSA key has 1024 bits, expected at least 2048 (rsa-key-size)",
+				"signature algorithm SHA1-RSA is weak (weak-signature)",
+			}))
+		})
+
+		it("rejects naming the binding and key", func() {
+			ts.Policy = truststore.StrictPolicy
+
+			err := ts.AddBindings(binds, truststore.BindingKeyFilter{})
+			Expect(err).To(MatchError(`CA certificate "CN=Weak RSA SHA1 CA" from key "weak-rsa-sha1.pem" of binding "some-binding" violates the "strict" policy: ` +
+				`RSA key has 1024 bits, expected at least 2048 (rsa-key-size), signature algorithm SHA1-RSA is weak (weak-signature)`))
+			var policyErr truststore.PolicyError
+			Expect(errors.As(err, &policyErr)).To(BeTrue())
+			Expect(ts.Len()).To(Equal(0))
+		})
+
+		it("adds rejected certificates and records the reason if Audit is true", func() {
+			ts.Policy = truststore.StrictPolicy
+			ts.Audit = true
+
+			Expect(ts.AddBindings(binds, truststore.BindingKeyFilter{})).To(Succeed())
+			Expect(ts.Certificates()[0].Rejected).To(Equal(`violates the "strict" policy: ` +
+				`RSA key has 1024 bits, expected at least 2048 (rsa-key-size), signature algorithm SHA1-RSA is weak (weak-signature)`))
+		})
+	})
+}
@@ -0,0 +1,391 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package truststore loads CA certificates from bindings, build plan entries, files and PEM data and writes them to
+// the formats used by language runtimes: OpenSSL hash directories, PEM bundles and Java keystores.
+package truststore
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/buildpacks/libcnb"
+
+	"github.com/paketo-buildpacks/libpak/bard"
+)
+
+// Kinds of Origin.
+const (
+	// OriginBinding indicates a certificate provided by a ca-certificates binding.
+	OriginBinding = "binding"
+
+	// OriginPlanEntry indicates a certificate provided by a ca-certificates build plan entry.
+	OriginPlanEntry = "plan"
+
+	// OriginFile indicates a certificate read from a file.
+	OriginFile = "file"
+
+	// OriginPEM indicates a certificate provided as PEM data, e.g. in an environment variable.
+	OriginPEM = "pem"
+)
+
+// Origin describes where a certificate was loaded from.
+type Origin struct {
+	// Kind is one of OriginBinding, OriginPlanEntry, OriginFile or OriginPEM.
+	Kind string `json:"kind"`
+
+	// Name is the name of the binding, the source of the plan entry or the name of the PEM data.
+	Name string `json:"name,omitempty"`
+
+	// Key is the key of the binding.
+	Key string `json:"key,omitempty"`
+
+	// Path is the path of the file the certificate was read from, empty for PEM data.
+	Path string `json:"path,omitempty"`
+
+	// Index is the position of the certificate in the file or PEM data.
+	Index int `json:"index"`
+}
+
+func (o Origin) String() string {
+	switch o.Kind {
+	case OriginBinding:
+		return fmt.Sprintf("key %q of binding %q", o.Key, o.Name)
+	case OriginPlanEntry:
+		if o.Path != "" {
+			return fmt.Sprintf("%q of plan entry %q", o.Path, o.Name)
+		}
+		return fmt.Sprintf("plan entry %q", o.Name)
+	case OriginPEM:
+		return o.Name
+	default:
+		return fmt.Sprintf("%q", o.Path)
+	}
+}
+
+// Certificate is a certificate in a Truststore.
+type Certificate struct {
+	*x509.Certificate
+
+	// Fingerprint is the hex encoded SHA-256 digest of the certificate, see Fingerprint.
+	Fingerprint string
+
+	// Origins are the origins of the certificate, in the order it was added from them.
+	Origins []Origin
+
+	// Path is the path of a file containing only this certificate as a PEMTypeCertificate block, if there is one. It is
+	// not set if Integrity or Pinned is enabled, as the file may change after it was verified, so that WriteFiles
+	// writes a copy of the verified certificate instead.
+	Path string
+
+	// Warnings are the violations of rules of the Policy with action PolicyWarn.
+	Warnings []Violation
+
+	// Rejected is the reason the certificate was rejected, only set if the Truststore audits certificates, see Audit.
+	Rejected string
+}
+
+// Truststore is a set of CA certificates. Certificates are identified by their fingerprint, adding a certificate
+// a second time only records the additional origin.
+type Truststore struct {
+	Logger bard.Logger
+
+	// Policy is applied to every certificate added to the truststore.
+	Policy Policy
+
+	// Integrity, if enabled, restricts the certificates that can be added to the truststore.
+	Integrity Integrity
+
+	// Pinned, if enabled, restricts the certificates that can be added to the truststore like Integrity. Certificates
+	// must satisfy both, so that restrictions pinned at build time cannot be lifted by the configuration at runtime.
+	Pinned Integrity
+
+	// Events, if set, is called for each certificate that is skipped or rejected.
+	Events func(Event)
+
+	// Audit, if true, adds certificates that the Policy, Integrity or Pinned reject instead of returning an error and
+	// records the reason in Certificate.Rejected. It is meant for inspecting certificates, not for trusting them.
+	Audit bool
+
+	certs []*Certificate
+	index map[string]*Certificate
+}
+
+// New creates an empty Truststore.
+func New() *Truststore {
+	return &Truststore{index: map[string]*Certificate{}}
+}
+
+// Add adds cert from origin and returns false if the truststore already contained it. If cert violates a rule of
+// the Policy with action PolicyReject, a PolicyError is returned and cert is not added. If Integrity is enabled and
+// the fingerprint of cert is not allowed, an IntegrityError is returned. If Audit is true, cert is added instead.
+func (t *Truststore) Add(cert *x509.Certificate, origin Origin) (bool, error) {
+	return t.add(cert, origin, signatures{})
+}
+
+// signatures records whether a file is signed by one of the signing keys of Integrity and of Pinned.
+type signatures struct {
+	integrity bool
+	pinned    bool
+}
+
+// verified returns true if Integrity or Pinned is enabled, i.e. if certificates are verified.
+func (t *Truststore) verified() bool {
+	return t.Integrity.Enabled() || t.Pinned.Enabled()
+}
+
+func (t *Truststore) add(cert *x509.Certificate, origin Origin, signed signatures) (bool, error) {
+	fingerprint := Fingerprint(cert)
+	if c, ok := t.index[fingerprint]; ok {
+		t.Logger.Bodyf("Skipping duplicate certificate %q from %s, already added from %s", cert.Subject, origin, c.Origins[0])
+		t.emit(EventSkipped, cert, origin, fmt.Sprintf("duplicate of certificate from %s", c.Origins[0]))
+		c.Origins = append(c.Origins, origin)
+		return false, nil
+	}
+
+	var rejected string
+	if !t.Integrity.permits(fingerprint, signed.integrity) || !t.Pinned.permits(fingerprint, signed.pinned) {
+		rejected = "fingerprint is not allowed and file is not signed by a trusted key"
+		t.emit(EventRejected, cert, origin, rejected)
+		if !t.Audit {
+			return false, IntegrityError{Subject: cert.Subject.String(), Fingerprint: fingerprint, Origin: origin}
+		}
+	}
+
+	var warnings, rejections []Violation
+	for _, v := range t.Policy.Evaluate(cert) {
+		if v.Action == PolicyReject {
+			rejections = append(rejections, v)
+		} else {
+			warnings = append(warnings, v)
+		}
+	}
+	if len(rejections) > 0 && rejected == "" {
+		rejected = fmt.Sprintf("violates the %q policy: %s", t.Policy.Name, reasons(rejections))
+		t.emit(EventRejected, cert, origin, rejected)
+		if !t.Audit {
+			return false, PolicyError{Policy: t.Policy.Name, Subject: cert.Subject.String(), Origin: origin, Violations: rejections}
+		}
+	}
+	if len(warnings) > 0 {
+		t.Logger.Bodyf("Warning: CA certificate %q from %s violates the %q policy: %s",
+			cert.Subject, origin, t.Policy.Name, reasons(warnings))
+	}
+
+	c := &Certificate{Certificate: cert, Fingerprint: fingerprint, Origins: []Origin{origin}, Warnings: warnings, Rejected: rejected}
+	t.certs = append(t.certs, c)
+	t.index[fingerprint] = c
+	return true, nil
+}
+
+// AddPEM adds the certificates in raw, see DecodeCertificates. It is an error if raw does not contain any certificates.
+func (t *Truststore) AddPEM(raw []byte, origin Origin) error {
+	certs, err := DecodeCertificates(raw)
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("failed to decode PEM data")
+	}
+	for i, cert := range certs {
+		o := origin
+		o.Index = i
+		if _, err := t.Add(cert, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddFile adds the certificates in the file at path, see DecodeCertificates.
+func (t *Truststore) AddFile(path string, origin Origin) error {
+	return t.addFile(path, origin, false, false)
+}
+
+// AddVerifiedFile adds the certificates in the file at path like AddFile, but without checking Integrity. It is meant
+// for copies of certificates that were verified before, e.g. certificates embedded into the image at build time.
+func (t *Truststore) AddVerifiedFile(path string, origin Origin) error {
+	return t.addFile(path, origin, false, true)
+}
+
+// AddBindingFile adds the certificates in a file of a ca-certificates binding. If the file is the chain of a
+// kubernetes.io/tls secret only its CA certificates are added.
+func (t *Truststore) AddBindingFile(f BindingFile) error {
+	return t.addFile(f.Path, Origin{Kind: OriginBinding, Name: f.Binding, Key: f.Key}, f.CAOnly, false)
+}
+
+// AddBindings adds the certificates in all files of ca-certificates bindings that match filter, see BindingFiles.
+func (t *Truststore) AddBindings(binds libcnb.Bindings, filter BindingKeyFilter) error {
+	for _, f := range BindingFiles(binds, filter, t.Logger) {
+		if err := t.AddBindingFile(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddPlanEntry adds the certificates of a ca-certificates build plan entry. Paths that are files of bindings, as
+// required by this buildpack's detect for ca-certificates bindings, are added with AddBindingFile.
+func (t *Truststore) AddPlanEntry(md PlanEntryMetadata, bindings []BindingFile) error {
+	files := map[string]BindingFile{}
+	for _, f := range bindings {
+		files[f.Path] = f
+	}
+
+	for _, p := range md.Paths {
+		if f, ok := files[p]; ok {
+			if err := t.AddBindingFile(f); err != nil {
+				return err
+			}
+		} else if err := t.AddFile(p, Origin{Kind: OriginPlanEntry, Name: md.Source}); err != nil {
+			return err
+		}
+	}
+	for i, c := range md.Certificates {
+		if err := t.AddPEM([]byte(c), Origin{Kind: OriginPlanEntry, Name: md.Source}); err != nil {
+			return fmt.Errorf("failed to load certificates[%d]\n%w", i, err)
+		}
+	}
+	return nil
+}
+
+func (t *Truststore) addFile(path string, origin Origin, caOnly bool, verified bool) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read file at path %q\n%w", path, err)
+	}
+	certs, err := DecodeCertificates(raw)
+	if err != nil {
+		return fmt.Errorf("failed to decode certificates from file at path %q\n%w", path, err)
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("failed to decode certificates from file at path %q\nfailed to decode PEM data", path)
+	}
+	signed := signatures{integrity: verified, pinned: verified}
+	if !verified {
+		if signed.integrity, err = t.Integrity.verifyFile(path, raw); err == nil {
+			signed.pinned, err = t.Pinned.verifyFile(path, raw)
+		}
+	}
+	if err != nil {
+		for i, cert := range certs {
+			o := origin
+			o.Path, o.Index = path, i
+			t.emit(EventRejected, cert, o, err.Error())
+		}
+		if !t.Audit {
+			return err
+		}
+		// the certificates are rejected as unsigned unless they are allowed by their fingerprint
+		signed = signatures{}
+	}
+	if (signed.integrity || signed.pinned) && !verified {
+		t.Logger.Bodyf("Verified signature of %q", path)
+	}
+
+	for i, cert := range certs {
+		o := origin
+		o.Path, o.Index = path, i
+		if caOnly && !cert.IsCA {
+			t.Logger.Bodyf("Ignoring non-CA certificate %q", cert.Subject)
+			t.emit(EventSkipped, cert, o, "not a CA certificate")
+			continue
+		}
+		if _, err := t.add(cert, o, signed); err != nil {
+			return err
+		}
+		if c := t.index[Fingerprint(cert)]; len(certs) == 1 && c.Path == "" && isCanonicalPEM(raw) && !t.verified() {
+			c.Path = path
+		}
+	}
+	return nil
+}
+
+// Remove removes the certificate with the given fingerprint and returns false if the truststore did not contain it.
+func (t *Truststore) Remove(fingerprint string) bool {
+	c, ok := t.index[fingerprint]
+	if !ok {
+		return false
+	}
+	delete(t.index, fingerprint)
+	t.certs = slices.DeleteFunc(t.certs, func(other *Certificate) bool { return other == c })
+	return true
+}
+
+// Len returns the number of certificates.
+func (t *Truststore) Len() int {
+	return len(t.certs)
+}
+
+// Certificates returns the certificates in the order they were first added.
+func (t *Truststore) Certificates() []Certificate {
+	certs := make([]Certificate, len(t.certs))
+	for i, c := range t.certs {
+		certs[i] = *c
+		certs[i].Origins = append([]Origin{}, c.Origins...)
+		certs[i].Warnings = slices.Clone(c.Warnings)
+	}
+	return certs
+}
+
+// WriteFiles returns the paths of files that each contain exactly one of the certificates, see WriteFiles.
+func (t *Truststore) WriteFiles(dir string) ([]string, error) {
+	return WriteFiles(t.Certificates(), dir)
+}
+
+// Write writes the certificates to each of the outputs.
+func (t *Truststore) Write(outputs ...Output) error {
+	certs := t.Certificates()
+	for _, o := range outputs {
+		if err := o.Write(certs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteFiles returns the paths of files that each contain exactly one of the certificates, in the order of the
+// certificates. Certificates that were loaded from such a file keep its path, all others are written to new files in
+// dir named after their first origin, e.g. "cert_1_tls.crt" for the second certificate in "tls.crt".
+func WriteFiles(certs []Certificate, dir string) ([]string, error) {
+	var paths []string
+	for _, c := range certs {
+		if c.Path != "" {
+			paths = append(paths, c.Path)
+			continue
+		}
+
+		o := c.Origins[0]
+		name := filepath.Base(o.Path)
+		if o.Path == "" {
+			name = o.Name
+			if name == "" {
+				name = o.Kind
+			}
+			name = strings.NewReplacer("/", "_", string(filepath.Separator), "_").Replace(name) + ".pem"
+		}
+		path, err := writeUniqueFile(dir, fmt.Sprintf("cert_%d_%s", o.Index, name), EncodePEM(c.Certificate), FileMode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write certificate %q to file\n%w", c.Subject, err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
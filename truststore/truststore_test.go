@@ -0,0 +1,207 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package truststore_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildpacks/libcnb"
+	. "github.com/onsi/gomega"
+	"github.com/paketo-buildpacks/libpak/bard"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/ca-certificates/v3/truststore"
+)
+
+func testTruststore(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		buf *bytes.Buffer
+		ts  *truststore.Truststore
+	)
+
+	it.Before(func() {
+		buf = &bytes.Buffer{}
+		ts = truststore.New()
+		ts.Logger = bard.NewLogger(buf)
+	})
+
+	it("keeps the path of files containing a single certificate", func() {
+		Expect(ts.AddFile(filepath.Join("testdata", "SecureTrust_CA.pem"), truststore.Origin{Kind: truststore.OriginFile})).To(Succeed())
+
+		certs := ts.Certificates()
+		Expect(certs).To(HaveLen(1))
+		Expect(certs[0].Subject.CommonName).To(Equal("SecureTrust CA"))
+		Expect(certs[0].Path).To(Equal(filepath.Join("testdata", "SecureTrust_CA.pem")))
+		Expect(certs[0].Origins).To(Equal([]truststore.Origin{
+			{Kind: truststore.OriginFile, Path: filepath.Join("testdata", "SecureTrust_CA.pem")},
+		}))
+	})
+
+	it("does not keep the path of files containing a certificate in another format", func() {
+		Expect(ts.AddFile(filepath.Join("testdata", "SecureTrust_CA-trusted.pem"), truststore.Origin{Kind: truststore.OriginFile})).To(Succeed())
+
+		certs := ts.Certificates()
+		Expect(certs).To(HaveLen(1))
+		Expect(certs[0].Subject.CommonName).To(Equal("SecureTrust CA"))
+		Expect(certs[0].Path).To(BeEmpty())
+	})
+
+	it("adds binary DER and PKCS #7 files", func() {
+		Expect(ts.AddFile(filepath.Join("testdata", "SecureTrust_CA.der"), truststore.Origin{Kind: truststore.OriginFile})).To(Succeed())
+		Expect(ts.AddFile(filepath.Join("testdata", "multiple-certs.p7b"), truststore.Origin{Kind: truststore.OriginFile})).To(Succeed())
+
+		certs := ts.Certificates()
+		Expect(certs).To(HaveLen(2))
+		Expect(certs[0].Subject.CommonName).To(Equal("SecureTrust CA"))
+		Expect(certs[0].Path).To(BeEmpty())
+		Expect(certs[0].Origins).To(HaveLen(2))
+	})
+
+	it("dedupes certificates and records each origin", func() {
+		raw, err := os.ReadFile(filepath.Join("testdata", "SecureTrust_CA.pem"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(ts.AddFile(filepath.Join("testdata", "multiple-certs.pem"), truststore.Origin{Kind: truststore.OriginFile})).To(Succeed())
+		Expect(ts.AddPEM(raw, truststore.Origin{Kind: truststore.OriginPEM, Name: "SOME_PEM"})).To(Succeed())
+
+		Expect(ts.Len()).To(Equal(2))
+		certs := ts.Certificates()
+		Expect(certs[0].Path).To(BeEmpty())
+		Expect(certs[0].Origins).To(Equal([]truststore.Origin{
+			{Kind: truststore.OriginFile, Path: filepath.Join("testdata", "multiple-certs.pem")},
+			{Kind: truststore.OriginPEM, Name: "SOME_PEM"},
+		}))
+		Expect(certs[1].Origins).To(Equal([]truststore.Origin{
+			{Kind: truststore.OriginFile, Path: filepath.Join("testdata", "multiple-certs.pem"), Index: 1},
+		}))
+		Expect(buf.String()).To(ContainSubstring(`Skipping duplicate certificate "CN=SecureTrust CA,O=SecureTrust Corporation,C=US" from SOME_PEM`))
+	})
+
+	it("removes certificates by fingerprint", func() {
+		Expect(ts.AddFile(filepath.Join("testdata", "multiple-certs.pem"), truststore.Origin{Kind: truststore.OriginFile})).To(Succeed())
+		fingerprint := ts.Certificates()[0].Fingerprint
+
+		Expect(ts.Remove(fingerprint)).To(BeTrue())
+		Expect(ts.Remove(fingerprint)).To(BeFalse())
+		Expect(ts.Len()).To(Equal(1))
+		Expect(ts.Certificates()[0].Fingerprint).NotTo(Equal(fingerprint))
+	})
+
+	it("returns an error if PEM data does not contain certificates", func() {
+		Expect(ts.AddPEM([]byte("not-pem"), truststore.Origin{Kind: truststore.OriginPEM})).To(MatchError("failed to decode PEM data"))
+		Expect(ts.AddFile(filepath.Join("testdata", "tls-secret", "tls.key"), truststore.Origin{Kind: truststore.OriginFile})).
+			To(MatchError(ContainSubstring("failed to decode certificates from file at path")))
+	})
+
+	context("bindings", func() {
+		var binds libcnb.Bindings
+
+		it.Before(func() {
+			binds = libcnb.Bindings{
+				{
+					Name:   "some-tls-secret",
+					Type:   truststore.BindingType,
+					Path:   filepath.Join("testdata", "tls-secret"),
+					Secret: map[string]string{"tls.crt": "", "tls.key": ""},
+				},
+			}
+		})
+
+		it("only adds the CA certificates from the chain of a TLS secret", func() {
+			Expect(ts.AddBindings(binds, truststore.BindingKeyFilter{})).To(Succeed())
+
+			certs := ts.Certificates()
+			Expect(certs).To(HaveLen(1))
+			Expect(certs[0].Subject.CommonName).To(Equal("Test Root CA"))
+			Expect(certs[0].Origins).To(Equal([]truststore.Origin{{
+				Kind:  truststore.OriginBinding,
+				Name:  "some-tls-secret",
+				Key:   "tls.crt",
+				Path:  filepath.Join("testdata", "tls-secret", "tls.crt"),
+				Index: 1,
+			}}))
+			Expect(buf.String()).To(ContainSubstring(`Ignoring non-CA certificate "CN=example.com"`))
+		})
+
+		it("adds plan entry paths of bindings with their binding origin", func() {
+			files := truststore.BindingFiles(binds, truststore.BindingKeyFilter{}, bard.Logger{})
+			md := truststore.PlanEntryMetadata{
+				Version: truststore.PlanEntryMetadataVersion,
+				Paths: []string{
+					filepath.Join("testdata", "tls-secret", "tls.crt"),
+					filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"),
+				},
+				Source: "some-buildpack",
+			}
+			Expect(ts.AddPlanEntry(md, files)).To(Succeed())
+
+			certs := ts.Certificates()
+			Expect(certs).To(HaveLen(2))
+			Expect(certs[0].Origins[0].Kind).To(Equal(truststore.OriginBinding))
+			Expect(certs[1].Origins).To(Equal([]truststore.Origin{{
+				Kind: truststore.OriginPlanEntry,
+				Name: "some-buildpack",
+				Path: filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"),
+			}}))
+		})
+	})
+
+	context("WriteFiles", func() {
+		it("writes certificates without a file of their own", func() {
+			raw, err := os.ReadFile(filepath.Join("testdata", "tls-secret", "tls.crt"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ts.AddFile(filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"), truststore.Origin{Kind: truststore.OriginFile})).To(Succeed())
+			Expect(ts.AddPEM(raw, truststore.Origin{Kind: truststore.OriginPlanEntry, Name: "some/buildpack"})).To(Succeed())
+
+			dir := t.TempDir()
+			paths, err := ts.WriteFiles(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(paths).To(Equal([]string{
+				filepath.Join("testdata", "Go_Daddy_Class_2_CA.pem"),
+				filepath.Join(dir, "cert_0_some_buildpack.pem"),
+				filepath.Join(dir, "cert_1_some_buildpack.pem"),
+			}))
+
+			raw, err = os.ReadFile(paths[2])
+			Expect(err).NotTo(HaveOccurred())
+			certs, err := truststore.DecodeCertificates(raw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(certs).To(HaveLen(1))
+			Expect(truststore.Fingerprint(certs[0])).To(Equal(ts.Certificates()[2].Fingerprint))
+
+			info, err := os.Stat(paths[1])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(truststore.FileMode))
+		})
+	})
+
+	context("MkdirTemp", func() {
+		it("creates a directory readable by other users", func() {
+			dir, err := truststore.MkdirTemp(t.TempDir(), "some-")
+			Expect(err).NotTo(HaveOccurred())
+
+			info, err := os.Stat(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(truststore.DirMode))
+		})
+	})
+}